@@ -0,0 +1,589 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tennis-api/fixtures"
+	"tennis-api/rating"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Returned when a result can't be taken back because the players have already moved on in the draw
+var errBracketAdvanced = errors.New("a player from this match has already played their next match in the draw")
+
+// A match in a comp's draw, as stored
+type bracketSlot struct {
+	ID       int
+	CompID   int
+	Bracket  string
+	Round    int
+	Position int
+	Entrants [2]*int
+	Byes     [2]bool
+	Seeds    [2]*int
+	MatchID  *int
+	WinnerID *int
+	Decided  bool
+	Date     time.Time
+	WinnerTo *slotRef
+	LoserTo  *slotRef
+	Reset    bool
+}
+
+// One side of a stored slot
+type slotRef struct {
+	SlotID int
+	Side   int
+}
+
+const bracketSlotColumns = `id, comp_id, bracket, round, position, player1_id, player2_id, player1_bye, player2_bye, seed1, seed2,
+	match_id, winner_id, decided, date, winner_to_slot, winner_to_side, loser_to_slot, loser_to_side, reset`
+
+func scanBracketSlot(row interface{ Scan(...interface{}) error }) (bracketSlot, error) {
+	var slot bracketSlot
+	var winnerSlot, winnerSide, loserSlot, loserSide *int
+	err := row.Scan(&slot.ID, &slot.CompID, &slot.Bracket, &slot.Round, &slot.Position, &slot.Entrants[0], &slot.Entrants[1],
+		&slot.Byes[0], &slot.Byes[1], &slot.Seeds[0], &slot.Seeds[1], &slot.MatchID, &slot.WinnerID, &slot.Decided, &slot.Date,
+		&winnerSlot, &winnerSide, &loserSlot, &loserSide, &slot.Reset)
+	if winnerSlot != nil && winnerSide != nil {
+		slot.WinnerTo = &slotRef{SlotID: *winnerSlot, Side: *winnerSide}
+	}
+	if loserSlot != nil && loserSide != nil {
+		slot.LoserTo = &slotRef{SlotID: *loserSlot, Side: *loserSide}
+	}
+	return slot, err
+}
+
+func getBracketSlot(q queryer, slotID int) (bracketSlot, error) {
+	return scanBracketSlot(q.QueryRow(`SELECT `+bracketSlotColumns+` FROM bracket_slot WHERE id = $1`, slotID))
+}
+
+// Returns the comp's draw, bracket by bracket and round by round
+func getBracketSlots(q queryer, compID int) ([]bracketSlot, error) {
+	rows, err := q.Query(`SELECT `+bracketSlotColumns+` FROM bracket_slot WHERE comp_id = $1 ORDER BY id`, compID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slots := []bracketSlot{}
+	for rows.Next() {
+		slot, err := scanBracketSlot(rows)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+	return slots, rows.Err()
+}
+
+// Helper function
+//
+// Stores a draw for the comp, the first round's matches are created and byes are played through
+// Each stage is daysBetweenRounds after the one before, all matches in the draw use the format
+func createDraw(q queryer, compID int, slots []fixtures.Slot, startDate time.Time, daysBetweenRounds int, format MatchFormat) error {
+	ids := make([]int, len(slots))
+	for i, slot := range slots {
+		var entrants [2]*int
+		var byes [2]bool
+		var seeds [2]*int
+		for side := range slot.Entrants {
+			switch slot.Entrants[side] {
+			case fixtures.Bye:
+				byes[side] = true
+			case 0:
+			default:
+				entrants[side] = &slot.Entrants[side]
+			}
+			if slot.Seeds[side] != 0 {
+				seeds[side] = &slot.Seeds[side]
+			}
+		}
+
+		sqlStatement := `INSERT INTO bracket_slot (comp_id, bracket, round, position, player1_id, player2_id, player1_bye, player2_bye,
+			seed1, seed2, decided, date, reset)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, false, $11, $12)
+		RETURNING id`
+		date := startDate.AddDate(0, 0, (slot.Stage-1)*daysBetweenRounds)
+		err := q.QueryRow(sqlStatement, compID, slot.Bracket, slot.Round, slot.Position, entrants[0], entrants[1], byes[0], byes[1],
+			seeds[0], seeds[1], date, slot.Reset).Scan(&ids[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	// Link the slots now they all have ids
+	for i, slot := range slots {
+		var winnerSlot, winnerSide, loserSlot, loserSide *int
+		if slot.WinnerTo != nil {
+			winnerSlot, winnerSide = &ids[slot.WinnerTo.Slot], &slot.WinnerTo.Side
+		}
+		if slot.LoserTo != nil {
+			loserSlot, loserSide = &ids[slot.LoserTo.Slot], &slot.LoserTo.Side
+		}
+		sqlStatement := `UPDATE bracket_slot SET winner_to_slot = $2, winner_to_side = $3, loser_to_slot = $4, loser_to_side = $5 WHERE id = $1`
+		_, err := q.Exec(sqlStatement, ids[i], winnerSlot, winnerSide, loserSlot, loserSide)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		if err := settleSlot(q, id, format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper function
+//
+// Creates the slot's match once both players are known
+// A player with a bye goes straight through, and a slot with two byes passes a bye on
+func settleSlot(q queryer, slotID int, format MatchFormat) error {
+	slot, err := getBracketSlot(q, slotID)
+	if err != nil {
+		return err
+	}
+
+	if slot.Decided || slot.MatchID != nil {
+		return nil
+	}
+	for side := range slot.Entrants {
+		if slot.Entrants[side] == nil && !slot.Byes[side] {
+			return nil
+		}
+	}
+
+	switch {
+	case slot.Byes[0] && slot.Byes[1]:
+		return decideSlot(q, slot, nil, nil, format)
+	case slot.Byes[0]:
+		return decideSlot(q, slot, slot.Entrants[1], nil, format)
+	case slot.Byes[1]:
+		return decideSlot(q, slot, slot.Entrants[0], nil, format)
+	}
+
+	match, _, _, err := createMatch(q, slot.CompID, slot.Date, &slot.Round, []int{*slot.Entrants[0], *slot.Entrants[1]}, format)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`UPDATE bracket_slot SET match_id = $2 WHERE id = $1`, slot.ID, match.MatchID)
+	return err
+}
+
+// Helper function
+//
+// Records the winner of the slot and moves the winner and loser on, a nil player is a bye
+func decideSlot(q queryer, slot bracketSlot, winnerID, loserID *int, format MatchFormat) error {
+	_, err := q.Exec(`UPDATE bracket_slot SET winner_id = $2, decided = true WHERE id = $1`, slot.ID, winnerID)
+	if err != nil {
+		return err
+	}
+
+	if slot.WinnerTo != nil {
+		if err = placeEntrant(q, *slot.WinnerTo, winnerID, format); err != nil {
+			return err
+		}
+	}
+	if slot.LoserTo != nil {
+		if err = placeEntrant(q, *slot.LoserTo, loserID, format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Puts the player, or a bye if nil, into one side of a slot and settles it
+func placeEntrant(q queryer, ref slotRef, playerID *int, format MatchFormat) error {
+	sqlStatement := `UPDATE bracket_slot SET player1_id = $2, player1_bye = $3 WHERE id = $1`
+	if ref.Side == 1 {
+		sqlStatement = `UPDATE bracket_slot SET player2_id = $2, player2_bye = $3 WHERE id = $1`
+	}
+	_, err := q.Exec(sqlStatement, ref.SlotID, playerID, playerID == nil)
+	if err != nil {
+		return err
+	}
+	return settleSlot(q, ref.SlotID, format)
+}
+
+// Helper function
+//
+// Moves the winner and loser of a finished match on through the draw, abandoned matches have no winner and stay where they are
+func advanceBracket(q queryer, matchID int) error {
+	slot, err := scanBracketSlot(q.QueryRow(`SELECT `+bracketSlotColumns+` FROM bracket_slot WHERE match_id = $1`, matchID))
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var winnerID *int
+	err = q.QueryRow(`SELECT winner_id FROM match_result WHERE match_id = $1`, matchID).Scan(&winnerID)
+	if err != nil || winnerID == nil {
+		return err
+	}
+
+	// The winner is stored as the lead player of their team, so work out which entrant that is
+	winner, loser := slot.Entrants[0], slot.Entrants[1]
+	if *slot.Entrants[1] == *winnerID {
+		winner, loser = slot.Entrants[1], slot.Entrants[0]
+	}
+
+	// A reset is only played if the losers bracket winner took the final, otherwise the main bracket winner takes it unplayed
+	if slot.WinnerTo != nil && winner == slot.Entrants[0] {
+		next, err := getBracketSlot(q, slot.WinnerTo.SlotID)
+		if err != nil {
+			return err
+		}
+		if next.Reset {
+			_, err = q.Exec(`UPDATE bracket_slot SET winner_id = $2, decided = true WHERE id = $1`, slot.ID, winner)
+			if err != nil {
+				return err
+			}
+			sqlStatement := `UPDATE bracket_slot SET player1_id = $2, player2_id = $3, winner_id = $2, decided = true WHERE id = $1`
+			_, err = q.Exec(sqlStatement, next.ID, winner, loser)
+			return err
+		}
+	}
+
+	format, err := getMatchFormat(q, matchID)
+	if err != nil {
+		return err
+	}
+	return decideSlot(q, slot, winner, loser, *format)
+}
+
+// Helper function
+//
+// Takes a match's result back out of the draw, removing its players from the slots they moved on to
+// Returns errBracketAdvanced if either player's next match has started
+func retractBracket(q queryer, matchID int) error {
+	var slotID int
+	err := q.QueryRow(`SELECT id FROM bracket_slot WHERE match_id = $1 AND decided`, matchID).Scan(&slotID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return retractSlot(q, slotID)
+}
+
+// Undecides the slot, along with any slots its players went on to through byes
+func retractSlot(q queryer, slotID int) error {
+	slot, err := getBracketSlot(q, slotID)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range []*slotRef{slot.WinnerTo, slot.LoserTo} {
+		if ref == nil {
+			continue
+		}
+
+		next, err := getBracketSlot(q, ref.SlotID)
+		if err != nil {
+			return err
+		}
+
+		// A match that hasn't started can be removed, one that has means the draw has moved on
+		if next.MatchID != nil {
+			status, err := getMatchStatus(q, *next.MatchID)
+			if err != nil {
+				return err
+			} else if status != StatusScheduled {
+				return errBracketAdvanced
+			}
+			if err = deleteMatch(q, *next.MatchID); err != nil {
+				return err
+			}
+		} else if next.Decided {
+			if err = retractSlot(q, next.ID); err != nil {
+				return err
+			}
+		}
+
+		sqlStatement := `UPDATE bracket_slot SET player1_id = NULL, player1_bye = false, match_id = NULL, decided = false, winner_id = NULL WHERE id = $1`
+		if ref.Side == 1 {
+			sqlStatement = `UPDATE bracket_slot SET player2_id = NULL, player2_bye = false, match_id = NULL, decided = false, winner_id = NULL WHERE id = $1`
+		}
+		if _, err = q.Exec(sqlStatement, next.ID); err != nil {
+			return err
+		}
+	}
+
+	_, err = q.Exec(`UPDATE bracket_slot SET winner_id = NULL, decided = false WHERE id = $1`, slot.ID)
+	return err
+}
+
+// Helper function
+//
+// Returns the registered players in the comp in seed order, by their rating in the comp, then their global rating
+// Players without a rating are seeded as if they had the default rating, then in the order they joined
+func getSeededPlayers(q queryer, compID int) ([]int, error) {
+	sqlStatement := `SELECT r.player_id FROM comp_reg r
+	LEFT JOIN player_rating c ON c.player_id = r.player_id AND c.comp_id = r.comp_id
+	LEFT JOIN player_rating g ON g.player_id = r.player_id AND g.comp_id = $2
+	WHERE r.comp_id = $1 AND (r.pending IS NULL OR r.pending = false)
+	ORDER BY COALESCE(c.rating, g.rating, $3) DESC, r.reg_date, r.player_id`
+	return queryMatchIDs(sqlStatement, compID, globalRatings, rating.DefaultRating)
+}
+
+// Helper function
+//
+// Reads the seeding for a draw from the request, manual seeds first then everyone else in rating order
+// Responds with 400 and returns false if the seeds aren't all registered players
+func tryGetSeeding(c *gin.Context, q queryer, compID int, seeding string, seeds []int) ([]int, bool) {
+	players, err := getSeededPlayers(q, compID)
+	if handleError(err, c) {
+		return nil, false
+	}
+
+	switch seeding {
+	case "", SeedingRating:
+		return players, true
+	case SeedingManual:
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Seeding must be rating or manual"})
+		return nil, false
+	}
+
+	registered := map[int]bool{}
+	for _, playerID := range players {
+		registered[playerID] = true
+	}
+
+	ordered := []int{}
+	for _, playerID := range seeds {
+		if !registered[playerID] {
+			c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Seed " + strconv.Itoa(playerID) + " is not registered in the comp, or is seeded twice"})
+			return nil, false
+		}
+		registered[playerID] = false
+		ordered = append(ordered, playerID)
+	}
+	for _, playerID := range players {
+		if registered[playerID] {
+			ordered = append(ordered, playerID)
+		}
+	}
+	return ordered, true
+}
+
+// Endpoint: /comps/:id/draw/knockout
+//
+// Draws a knockout for the comp's registered players and creates the first round's matches
+// Players are seeded by rating, or by the seeds given with manual seeding, byes go to the top seeds
+// With consolation, players losing their first match play on in a back draw
+// Each round is daysBetweenRounds after the last, 7 by default, and every match uses the format given
+// Returns the bracket
+func createKnockout(c *gin.Context) {
+	createBracket(c, CompKnockout)
+}
+
+// Endpoint: /comps/:id/draw/double-elimination
+//
+// Draws a double elimination bracket for the comp's registered players, seeded as for a knockout
+// Losers drop into a losers bracket, whose winner plays the main bracket's winner in the final
+// Returns the bracket
+func createDoubleElimination(c *gin.Context) {
+	createBracket(c, CompDoubleElimination)
+}
+
+// Helper function
+//
+// Draws a bracket of the given comp type and responds with it
+func createBracket(c *gin.Context, compType string) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		StartDate         time.Time `form:"startDate" json:"startDate" binding:"required"`
+		DaysBetweenRounds *int      `form:"daysBetweenRounds" json:"daysBetweenRounds"`
+		Seeding           string    `form:"seeding" json:"seeding"`
+		Seeds             []int     `form:"seeds" json:"seeds"`
+		Seeded            *int      `form:"seeded" json:"seeded"`
+		Consolation       bool      `form:"consolation" json:"consolation"`
+		MatchFormatRequest
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	days := 7
+	if request.DaysBetweenRounds != nil {
+		days = *request.DaysBetweenRounds
+	}
+	if days < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "daysBetweenRounds can't be negative"})
+		return
+	}
+
+	format, err := buildMatchFormat(request.MatchFormatRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	exists, err := compHasFixtures(tx, compID)
+	if handleError(err, c) {
+		return
+	} else if exists {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Comp already has fixtures"})
+		return
+	}
+
+	players, ok := tryGetSeeding(c, tx, compID, request.Seeding, request.Seeds)
+	if !ok {
+		return
+	} else if len(players) < 2 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "A draw needs at least 2 players"})
+		return
+	}
+
+	// A quarter of the draw is seeded unless told otherwise, or everyone seeded by hand
+	seeded := fixtures.DrawSize(len(players)) / 4
+	if request.Seeding == SeedingManual {
+		seeded = len(request.Seeds)
+	}
+	if request.Seeded != nil {
+		seeded = *request.Seeded
+	}
+
+	slots := fixtures.Knockout(players, seeded)
+	if compType == CompDoubleElimination {
+		slots = fixtures.DoubleElimination(players, seeded)
+	} else if request.Consolation {
+		slots = fixtures.Consolation(players, seeded)
+	}
+
+	err = createDraw(tx, compID, slots, request.StartDate, days, format)
+	if handleError(err, c) {
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE comp SET comp_type = $2 WHERE id = $1`, compID, compType)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	getCompBracket(c)
+}
+
+// Endpoint: /comps/:id/bracket
+//
+// Returns the comp's draw for rendering, each bracket's rounds in order with the slots top to bottom
+// Slots link to the slot their winner, and loser where there is one, moves on to
+func getCompBracket(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	slots, err := getBracketSlots(db, compID)
+	if handleError(err, c) {
+		return
+	}
+
+	var response struct {
+		Type     *string   `json:"type"`
+		Brackets []Bracket `json:"brackets"`
+	}
+	err = db.QueryRow(`SELECT comp_type FROM comp WHERE id = $1`, compID).Scan(&response.Type)
+	if handleError(err, c) {
+		return
+	}
+
+	response.Brackets = []Bracket{}
+	players := map[int]*Player{}
+	for _, slot := range slots {
+		if len(response.Brackets) == 0 || response.Brackets[len(response.Brackets)-1].Name != slot.Bracket {
+			response.Brackets = append(response.Brackets, Bracket{Name: slot.Bracket, Rounds: [][]BracketSlot{}})
+		}
+		bracket := &response.Brackets[len(response.Brackets)-1]
+		for len(bracket.Rounds) < slot.Round {
+			bracket.Rounds = append(bracket.Rounds, []BracketSlot{})
+		}
+
+		out := BracketSlot{ID: slot.ID, Round: slot.Round, Position: slot.Position, Seeds: slot.Seeds, Byes: slot.Byes, Decided: slot.Decided,
+			Date: slot.Date, Reset: slot.Reset}
+		for side, playerID := range slot.Entrants {
+			if out.Players[side], err = getBracketPlayer(players, playerID); handleError(err, c) {
+				return
+			}
+		}
+		if out.Winner, err = getBracketPlayer(players, slot.WinnerID); handleError(err, c) {
+			return
+		}
+		if slot.MatchID != nil {
+			match, err := getMatch(*slot.MatchID)
+			if handleError(err, c) {
+				return
+			}
+			out.Match = &match
+		}
+		if slot.WinnerTo != nil {
+			out.WinnerTo = &BracketRef{SlotID: slot.WinnerTo.SlotID, Side: slot.WinnerTo.Side}
+		}
+		if slot.LoserTo != nil {
+			out.LoserTo = &BracketRef{SlotID: slot.LoserTo.SlotID, Side: slot.LoserTo.Side}
+		}
+
+		bracket.Rounds[slot.Round-1] = append(bracket.Rounds[slot.Round-1], out)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Returns the player, looking them up once per bracket
+func getBracketPlayer(players map[int]*Player, playerID *int) (*Player, error) {
+	if playerID == nil {
+		return nil, nil
+	}
+	if player, ok := players[*playerID]; ok {
+		return player, nil
+	}
+
+	player, err := getPlayer(db, *playerID)
+	if err != nil {
+		return nil, err
+	}
+	players[*playerID] = &player
+	return &player, nil
+}
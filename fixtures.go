@@ -0,0 +1,333 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"tennis-api/fixtures"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Returns the ids of the players registered in the comp who have accepted their invite, in the order they joined
+func getCompPlayerIDs(q queryer, compID int) ([]int, error) {
+	sqlStatement := `SELECT player_id FROM comp_reg
+	WHERE comp_id = $1 AND (pending IS NULL OR pending = false)
+	ORDER BY reg_date, player_id`
+	rows, err := q.Query(sqlStatement, compID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playerIDs := []int{}
+	for rows.Next() {
+		var playerID int
+		if err = rows.Scan(&playerID); err != nil {
+			return nil, err
+		}
+		playerIDs = append(playerIDs, playerID)
+	}
+	return playerIDs, rows.Err()
+}
+
+// Returns true if the comp already has matches scheduled in rounds
+func compHasFixtures(q queryer, compID int) (bool, error) {
+	var exists bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM match WHERE comp_id = $1 AND round IS NOT NULL)`, compID).Scan(&exists)
+	return exists, err
+}
+
+// Endpoint: /comps/:id/fixtures/round-robin
+//
+// Schedules a round robin between every registered player in the comp, twice with double
+// Rounds start on startDate and are daysBetweenRounds apart, 7 by default
+// Home and away are balanced unless balance is false, the home player serves first
+// Returns the rounds with their matches, and the players with a bye in each round
+func createRoundRobin(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		StartDate         time.Time `form:"startDate" json:"startDate" binding:"required"`
+		DaysBetweenRounds *int      `form:"daysBetweenRounds" json:"daysBetweenRounds"`
+		Double            bool      `form:"double" json:"double"`
+		Balance           *bool     `form:"balance" json:"balance"`
+		MatchFormatRequest
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	days := 7
+	if request.DaysBetweenRounds != nil {
+		days = *request.DaysBetweenRounds
+	}
+	if days < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "daysBetweenRounds can't be negative"})
+		return
+	}
+	balance := request.Balance == nil || *request.Balance
+
+	format, err := buildMatchFormat(request.MatchFormatRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	exists, err := compHasFixtures(tx, compID)
+	if handleError(err, c) {
+		return
+	} else if exists {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Comp already has fixtures"})
+		return
+	}
+
+	playerIDs, err := getCompPlayerIDs(tx, compID)
+	if handleError(err, c) {
+		return
+	} else if len(playerIDs) < 2 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "A round robin needs at least 2 players"})
+		return
+	}
+
+	var response struct {
+		Rounds []FixtureRound `json:"rounds"`
+	}
+	response.Rounds = []FixtureRound{}
+	matchIDs := []int{}
+
+	for i, pairings := range fixtures.RoundRobin(playerIDs, request.Double, balance) {
+		number := i + 1
+		round := FixtureRound{Round: number, Date: request.StartDate.AddDate(0, 0, i*days), Matches: []Match{}, Byes: []int{}}
+
+		for _, pairing := range pairings {
+			if pairing.IsBye() {
+				round.Byes = append(round.Byes, pairing.Home)
+				continue
+			}
+
+			match, _, _, err := createMatch(tx, compID, round.Date, &number, []int{pairing.Home, pairing.Away}, format)
+			if handleError(err, c) {
+				return
+			}
+			round.Matches = append(round.Matches, match)
+			matchIDs = append(matchIDs, match.MatchID)
+		}
+		response.Rounds = append(response.Rounds, round)
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+	for _, matchID := range matchIDs {
+		fireMatchWebhooks(matchID, WebhookMatchCreated)
+	}
+}
+
+// Endpoint: /comps/:id/fixtures/swiss
+//
+// Pairs the next round of a Swiss comp from the table, once every match in the round before has finished
+// Players meet the nearest player in the table they haven't played, and whoever has served first less often serves first
+// With an odd number of players the lowest placed player without a bye gets one, which is worth a win in the table
+// Returns the round with its matches and the player with a bye
+func createSwissRound(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		Date time.Time `form:"date" json:"date" binding:"required"`
+		MatchFormatRequest
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	format, err := buildMatchFormat(request.MatchFormatRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the comp so two requests can't pair the same round
+	var compType *string
+	err = tx.QueryRow(`SELECT comp_type FROM comp WHERE id = $1 FOR UPDATE`, compID).Scan(&compType)
+	if handleError(err, c) {
+		return
+	}
+
+	exists, err := compHasFixtures(tx, compID)
+	if handleError(err, c) {
+		return
+	} else if exists && (compType == nil || *compType != CompSwiss) {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Comp already has fixtures"})
+		return
+	}
+
+	var number int
+	var unfinished bool
+	sqlStatement := `SELECT COALESCE(MAX(round), 0) + 1, COALESCE(BOOL_OR(status IS DISTINCT FROM $2), false)
+	FROM match WHERE comp_id = $1 AND round IS NOT NULL`
+	err = tx.QueryRow(sqlStatement, compID, StatusCompleted).Scan(&number, &unfinished)
+	if handleError(err, c) {
+		return
+	} else if unfinished {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Every match in the round must finish before the next is paired"})
+		return
+	}
+
+	players, err := getSwissPlayers(tx, compID)
+	if handleError(err, c) {
+		return
+	} else if len(players) < 2 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "A Swiss round needs at least 2 players"})
+		return
+	}
+
+	round := FixtureRound{Round: number, Date: request.Date, Matches: []Match{}, Byes: []int{}}
+	for _, pairing := range fixtures.Swiss(players) {
+		if pairing.IsBye() {
+			_, err = tx.Exec(`INSERT INTO swiss_bye (comp_id, round, player_id) VALUES ($1, $2, $3)`, compID, number, pairing.Home)
+			if handleError(err, c) {
+				return
+			}
+			round.Byes = append(round.Byes, pairing.Home)
+			continue
+		}
+
+		match, _, _, err := createMatch(tx, compID, round.Date, &number, []int{pairing.Home, pairing.Away}, format)
+		if handleError(err, c) {
+			return
+		}
+		round.Matches = append(round.Matches, match)
+	}
+
+	_, err = tx.Exec(`UPDATE comp SET comp_type = $2 WHERE id = $1`, compID, CompSwiss)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, round)
+	for _, match := range round.Matches {
+		fireMatchWebhooks(match.MatchID, WebhookMatchCreated)
+	}
+}
+
+// Helper function
+//
+// Returns the comp's registered players in table order for pairing a Swiss round, players yet to play go last
+// Each has the players they have met in the comp and how often they have served first
+func getSwissPlayers(q queryer, compID int) ([]fixtures.SwissPlayer, error) {
+	standings, err := getCompStandings(q, compID)
+	if err != nil {
+		return nil, err
+	}
+
+	playerIDs, err := getCompPlayerIDs(q, compID)
+	if err != nil {
+		return nil, err
+	}
+
+	registered := map[int]bool{}
+	for _, playerID := range playerIDs {
+		registered[playerID] = true
+	}
+
+	players := []fixtures.SwissPlayer{}
+	index := map[int]int{}
+	for _, competitor := range standings {
+		if registered[competitor.Player.Id] {
+			index[competitor.Player.Id] = len(players)
+			players = append(players, fixtures.SwissPlayer{ID: competitor.Player.Id, Score: competitor.Points, Byes: competitor.Byes})
+		}
+	}
+	for _, playerID := range playerIDs {
+		if _, ok := index[playerID]; !ok {
+			index[playerID] = len(players)
+			players = append(players, fixtures.SwissPlayer{ID: playerID})
+		}
+	}
+
+	// Everyone each player has met, and who served the first point, in every match of the comp
+	// Matches from before teams were recorded alternate sides, the same as getMatchTeams
+	sqlStatement := `WITH side AS (
+		SELECT mp.match_id, mp.player_id, COALESCE(mp.team,
+			2 - ROW_NUMBER() OVER (PARTITION BY mp.match_id ORDER BY mp.team, mp.serve_order, mp.player_id) % 2) AS team
+		FROM match_participant mp
+	)
+	SELECT a.player_id, b.player_id, s.server_id = a.player_id
+	FROM match m
+	JOIN side a ON a.match_id = m.id
+	JOIN side b ON b.match_id = m.id AND b.team != a.team
+	LEFT JOIN point s ON s.match_id = m.id AND s.number = 1
+	WHERE m.comp_id = $1`
+	rows, err := q.Query(sqlStatement, compID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var playerID, opponentID int
+		var served *bool
+		if err = rows.Scan(&playerID, &opponentID, &served); err != nil {
+			return nil, err
+		}
+
+		i, ok := index[playerID]
+		if !ok {
+			continue
+		}
+		players[i].Opponents = append(players[i].Opponents, opponentID)
+		if served != nil && *served {
+			players[i].ServeBalance++
+		} else if served != nil {
+			players[i].ServeBalance--
+		}
+	}
+
+	return players, rows.Err()
+}
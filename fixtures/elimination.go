@@ -0,0 +1,105 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+// Consolation draws a knockout for the players with a back draw, a second knockout for the players who lose their first round match
+//
+// Players given a bye in the first round are through to the second, so they don't drop into the back draw.
+// Draws of fewer than 3 players have nobody to play in the back draw and are a plain knockout.
+func Consolation(players []int, seeded int) []Slot {
+	slots := Knockout(players, seeded)
+	size := DrawSize(len(players))
+	if size < 4 {
+		return slots
+	}
+
+	// The back draw's first round is played alongside the main draw's second
+	first := len(slots)
+	slots = addKnockout(slots, BracketConsolation, size/2, 2)
+	for position := 0; position < size/2; position++ {
+		slots[position].LoserTo = &Ref{Slot: first + position/2, Side: position % 2}
+	}
+
+	return slots
+}
+
+// DoubleElimination draws a knockout where players are out after losing twice
+//
+// Losers from the main bracket drop into a losers bracket, which alternates between rounds where its players play each other
+// and rounds where they meet the players dropping down from the next main round. Players dropping down are placed in
+// reverse order so they don't meet somebody they have just played. The winners of the two brackets meet in the final,
+// and if the losers bracket winner takes it both players have lost once, so they play again in a reset final.
+// Draws of fewer than 3 players are a plain knockout.
+func DoubleElimination(players []int, seeded int) []Slot {
+	slots := Knockout(players, seeded)
+	size := DrawSize(len(players))
+	if size < 4 {
+		return slots
+	}
+
+	// First slot of each main round, by round number
+	mainRounds := []int{0, 0}
+	for count := size / 2; count > 1; count /= 2 {
+		mainRounds = append(mainRounds, mainRounds[len(mainRounds)-1]+count)
+	}
+
+	// The first round of the losers bracket pairs the main draw's first round losers
+	round, stage := 1, 2
+	previous := []int{}
+	for position := 0; position < size/4; position++ {
+		previous = append(previous, len(slots))
+		slots[position*2].LoserTo = &Ref{Slot: len(slots), Side: 0}
+		slots[position*2+1].LoserTo = &Ref{Slot: len(slots), Side: 1}
+		slots = append(slots, Slot{Bracket: BracketLosers, Round: round, Stage: stage, Position: position})
+	}
+
+	for mainRound := 2; mainRound < len(mainRounds); mainRound++ {
+		// Losers from the main round drop in
+		round, stage = round+1, stage+1
+		dropIn := []int{}
+		for position, from := range previous {
+			dropIn = append(dropIn, len(slots))
+			slots[from].WinnerTo = &Ref{Slot: len(slots), Side: 0}
+			slots[mainRounds[mainRound]+len(previous)-1-position].LoserTo = &Ref{Slot: len(slots), Side: 1}
+			slots = append(slots, Slot{Bracket: BracketLosers, Round: round, Stage: stage, Position: position})
+		}
+		previous = dropIn
+
+		if len(previous) == 1 {
+			break
+		}
+
+		// Then play each other down to the size of the next main round
+		round, stage = round+1, stage+1
+		halved := []int{}
+		for position := 0; position < len(previous)/2; position++ {
+			halved = append(halved, len(slots))
+			slots[previous[position*2]].WinnerTo = &Ref{Slot: len(slots), Side: 0}
+			slots[previous[position*2+1]].WinnerTo = &Ref{Slot: len(slots), Side: 1}
+			slots = append(slots, Slot{Bracket: BracketLosers, Round: round, Stage: stage, Position: position})
+		}
+		previous = halved
+	}
+
+	// The main bracket's winner plays the losers bracket's winner, with the winner of the final on side 0 of the reset
+	final := len(slots)
+	slots[mainRounds[len(mainRounds)-1]].WinnerTo = &Ref{Slot: final, Side: 0}
+	slots[previous[0]].WinnerTo = &Ref{Slot: final, Side: 1}
+	slots = append(slots, Slot{Bracket: BracketFinal, Round: 1, Stage: stage + 1, Position: 0,
+		WinnerTo: &Ref{Slot: final + 1, Side: 0}, LoserTo: &Ref{Slot: final + 1, Side: 1}})
+	slots = append(slots, Slot{Bracket: BracketFinal, Round: 2, Stage: stage + 2, Position: 0, Reset: true})
+
+	return slots
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+import "testing"
+
+// Returns the number of slots in the bracket
+func bracketSlots(slots []Slot, bracket string) int {
+	n := 0
+	for _, slot := range slots {
+		if slot.Bracket == bracket {
+			n++
+		}
+	}
+	return n
+}
+
+func TestConsolation(t *testing.T) {
+	tests := []struct {
+		name        string
+		players     int
+		consolation int
+	}{
+		{"two players", 2, 0},
+		{"three players", 3, 1},
+		{"full draw", 8, 3},
+		{"byes", 11, 7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slots := Consolation(playerIDs(test.players), 0)
+
+			if consolation := bracketSlots(slots, BracketConsolation); consolation != test.consolation {
+				t.Errorf("%d back draw slots, want %d", consolation, test.consolation)
+			}
+
+			// Only first round losers drop into the back draw
+			for _, slot := range slots {
+				if (slot.LoserTo != nil) != (slot.Bracket == BracketMain && slot.Round == 1 && test.consolation > 0) {
+					t.Errorf("%s round %d slot has loser to %v", slot.Bracket, slot.Round, slot.LoserTo)
+				}
+			}
+
+			// Every first round loser plays again, so loses at most twice, and the best of them wins the back draw
+			result := playDraw(t, slots, lowerID)
+			for player, losses := range result.losses {
+				if losses > 2 {
+					t.Errorf("player %d lost %d times", player, losses)
+				}
+			}
+		})
+	}
+}
+
+func TestDoubleElimination(t *testing.T) {
+	tests := []struct {
+		name    string
+		players int
+		losers  int
+	}{
+		{"two players", 2, 0},
+		{"three players", 3, 2},
+		{"four players", 4, 2},
+		{"full draw", 8, 6},
+		{"byes", 13, 14},
+		{"large", 32, 30},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slots := DoubleElimination(playerIDs(test.players), 0)
+
+			if losers := bracketSlots(slots, BracketLosers); losers != test.losers {
+				t.Errorf("%d losers bracket slots, want %d", losers, test.losers)
+			}
+			if finals := bracketSlots(slots, BracketFinal); test.losers > 0 && finals != 2 {
+				t.Errorf("%d finals, want a final and a reset", finals)
+			}
+
+			// The champion never loses and everyone else is out after two defeats
+			result := playDraw(t, slots, lowerID)
+			if result.champion != 1 || result.losses[1] != 0 {
+				t.Errorf("champion %d with %d losses, want 1 unbeaten", result.champion, result.losses[1])
+			}
+			if test.losers == 0 {
+				return
+			}
+			for _, player := range playerIDs(test.players)[1:] {
+				if result.losses[player] != 2 {
+					t.Errorf("player %d lost %d times, want 2", player, result.losses[player])
+				}
+			}
+
+			// Players dropping down never meet the player they have just played, and no pair meets more than twice
+			if result.immediateRematches != 0 {
+				t.Errorf("%d immediate rematches", result.immediateRematches)
+			}
+			for match, times := range result.matches {
+				if times > 2 {
+					t.Errorf("%v played %d times", match, times)
+				}
+			}
+		})
+	}
+}
+
+func TestDoubleEliminationReset(t *testing.T) {
+	// Player 2 comes through the losers bracket, the winners of the final and reset are picked by the test
+	finals := func(first, reset int) pickWinner {
+		return func(slot Slot, a, b int) int {
+			switch {
+			case slot.Bracket != BracketFinal:
+				return lowerID(slot, a, b)
+			case slot.Round == 1:
+				return first
+			}
+			return reset
+		}
+	}
+
+	tests := []struct {
+		name     string
+		first    int
+		reset    int
+		champion int
+		losses   [2]int
+	}{
+		{"main bracket winner takes the final", 1, 0, 1, [2]int{0, 2}},
+		{"losers bracket winner forces a reset", 2, 1, 1, [2]int{1, 2}},
+		{"losers bracket winner takes the reset", 2, 2, 2, [2]int{2, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slots := DoubleElimination(playerIDs(8), 0)
+			// Player 2 drops into the losers bracket by losing to player 1 in the main bracket
+			result := playDraw(t, slots, finals(test.first, test.reset))
+			if result.champion != test.champion {
+				t.Errorf("champion %d, want %d", result.champion, test.champion)
+			}
+			if losses := [2]int{result.losses[1], result.losses[2]}; losses != test.losses {
+				t.Errorf("players 1 and 2 lost %v times, want %v", losses, test.losses)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+// Brackets a slot can belong to
+const (
+	BracketMain        = "main"
+	BracketConsolation = "consolation"
+	BracketLosers      = "losers"
+	BracketFinal       = "final"
+)
+
+// Ref points at one side of a slot, by its index in the draw
+type Ref struct {
+	Slot int `json:"slot"`
+	Side int `json:"side"`
+}
+
+// Slot is a match in a draw, its entrants are known in the first round and filled in by earlier slots after that
+//
+// An entrant is 0 while waiting for an earlier slot and Bye if there is nobody to play.
+// The winner moves on to WinnerTo and, in draws that give players a second chance, the loser to LoserTo.
+// Stage orders slots across brackets, a slot is played once every slot at an earlier stage it depends on has been.
+// A reset slot is only played if the side 1 entrant won the slot feeding it, otherwise that slot's winner takes it unplayed.
+type Slot struct {
+	Bracket  string `json:"bracket"`
+	Round    int    `json:"round"`
+	Stage    int    `json:"stage"`
+	Position int    `json:"position"`
+	Entrants [2]int `json:"entrants"`
+	Seeds    [2]int `json:"seeds"`
+	WinnerTo *Ref   `json:"winnerTo"`
+	LoserTo  *Ref   `json:"loserTo"`
+	Reset    bool   `json:"reset"`
+}
+
+// Returns the smallest power of two that fits n players
+func DrawSize(n int) int {
+	size := 1
+	for size < n {
+		size *= 2
+	}
+	return size
+}
+
+// Returns the seed placed at each line of a draw of the given size, so the top two seeds can only meet in the final,
+// the top four in the semi finals and so on, e.g. 1, 8, 4, 5, 2, 7, 3, 6 for 8
+func SeedOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		next := make([]int, 0, len(order)*2)
+		total := len(order)*2 + 1
+		for _, seed := range order {
+			next = append(next, seed, total-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// Knockout draws a single elimination bracket for the players, who are given in seed order
+//
+// The first seeded players are marked with their seed, the rest are placed by their order without one.
+// Fields that aren't a power of two are filled with byes, which go to the top seeds.
+// Slots are returned round by round, top to bottom, the last slot is the final.
+func Knockout(players []int, seeded int) []Slot {
+	if len(players) < 2 {
+		return []Slot{}
+	}
+
+	size := DrawSize(len(players))
+	lines := SeedOrder(size)
+	slots := addKnockout(nil, BracketMain, size, 1)
+
+	for position := 0; position < size/2; position++ {
+		for side := 0; side < 2; side++ {
+			seed := lines[position*2+side]
+			slots[position].Entrants[side] = Bye
+			if seed <= len(players) {
+				slots[position].Entrants[side] = players[seed-1]
+				if seed <= seeded {
+					slots[position].Seeds[side] = seed
+				}
+			}
+		}
+	}
+
+	return slots
+}
+
+// Appends an empty single elimination bracket for the given number of lines, a power of two,
+// with its first round played at the given stage
+func addKnockout(slots []Slot, bracket string, lines int, stage int) []Slot {
+	first := len(slots)
+	for round := 1; lines>>round > 0; round++ {
+		count := lines >> round
+		for position := 0; position < count; position++ {
+			slot := Slot{Bracket: bracket, Round: round, Stage: stage + round - 1, Position: position}
+			if count > 1 {
+				slot.WinnerTo = &Ref{Slot: first + count + position/2, Side: position % 2}
+			}
+			slots = append(slots, slot)
+		}
+		first += count
+	}
+	return slots
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The outcome of playing out a draw
+type drawResult struct {
+	losses   map[int]int
+	matches  map[[2]int]int
+	champion int
+	// Matches where the two players had just played each other
+	immediateRematches int
+}
+
+// Picks the winner of a match in the slot between players a and b
+type pickWinner func(slot Slot, a, b int) int
+
+// The lower id always wins
+func lowerID(slot Slot, a, b int) int {
+	if b < a {
+		return b
+	}
+	return a
+}
+
+// Plays out the draw with pick choosing the winner of every match, checking each slot is filled before it is played
+// and only sends players to slots at a later stage
+func playDraw(t *testing.T, slots []Slot, pick pickWinner) drawResult {
+	t.Helper()
+
+	entrants := make([][2]int, len(slots))
+	filled := make([][2]bool, len(slots))
+	for i, slot := range slots {
+		entrants[i] = slot.Entrants
+		for side, entrant := range slot.Entrants {
+			filled[i][side] = entrant != 0
+		}
+	}
+
+	result := drawResult{losses: map[int]int{}, matches: map[[2]int]int{}}
+	lastOpponent := map[int]int{}
+	played := make([]bool, len(slots))
+	for progress := true; progress; {
+		progress = false
+		for i, slot := range slots {
+			if played[i] || !filled[i][0] || !filled[i][1] {
+				continue
+			}
+			played[i], progress = true, true
+
+			winner, loser := entrants[i][0], entrants[i][1]
+			switch {
+			case winner == Bye:
+				winner, loser = loser, winner
+			case loser == Bye:
+			default:
+				if pick(slot, winner, loser) == loser {
+					winner, loser = loser, winner
+				}
+				result.losses[loser]++
+				result.matches[[2]int{winner, loser}]++
+				if lastOpponent[winner] == loser && lastOpponent[loser] == winner {
+					result.immediateRematches++
+				}
+				lastOpponent[winner], lastOpponent[loser] = loser, winner
+			}
+
+			// A reset isn't played when the side 0 entrant wins the slot before it
+			if slot.WinnerTo != nil && slots[slot.WinnerTo.Slot].Reset && winner == entrants[i][0] {
+				played[slot.WinnerTo.Slot] = true
+				result.champion = winner
+				continue
+			}
+
+			for _, move := range []struct {
+				to     *Ref
+				player int
+			}{{slot.WinnerTo, winner}, {slot.LoserTo, loser}} {
+				if move.to == nil {
+					continue
+				}
+				if slots[move.to.Slot].Stage <= slot.Stage {
+					t.Fatalf("slot %d at stage %d feeds slot %d at stage %d", i, slot.Stage, move.to.Slot, slots[move.to.Slot].Stage)
+				}
+				if filled[move.to.Slot][move.to.Side] {
+					t.Fatalf("slot %d side %d is fed twice", move.to.Slot, move.to.Side)
+				}
+				entrants[move.to.Slot][move.to.Side] = move.player
+				filled[move.to.Slot][move.to.Side] = true
+			}
+			if slot.WinnerTo == nil {
+				result.champion = winner
+			}
+		}
+	}
+
+	for i := range slots {
+		if !played[i] {
+			t.Fatalf("slot %d was never played: %+v", i, slots[i])
+		}
+	}
+	return result
+}
+
+func TestDrawSize(t *testing.T) {
+	tests := []struct{ players, size int }{{1, 1}, {2, 2}, {3, 4}, {4, 4}, {5, 8}, {16, 16}, {17, 32}}
+	for _, test := range tests {
+		if size := DrawSize(test.players); size != test.size {
+			t.Errorf("DrawSize(%d) = %d, want %d", test.players, size, test.size)
+		}
+	}
+}
+
+func TestSeedOrder(t *testing.T) {
+	tests := []struct {
+		size  int
+		order []int
+	}{
+		{1, []int{1}},
+		{2, []int{1, 2}},
+		{4, []int{1, 4, 2, 3}},
+		{8, []int{1, 8, 4, 5, 2, 7, 3, 6}},
+	}
+	for _, test := range tests {
+		if order := SeedOrder(test.size); !reflect.DeepEqual(order, test.order) {
+			t.Errorf("SeedOrder(%d) = %v, want %v", test.size, order, test.order)
+		}
+	}
+}
+
+func TestKnockout(t *testing.T) {
+	tests := []struct {
+		name    string
+		players int
+		seeded  int
+		slots   int
+		byes    int
+	}{
+		{"one player", 1, 0, 0, 0},
+		{"two players", 2, 2, 1, 0},
+		{"three players", 3, 1, 3, 1},
+		{"full draw", 8, 2, 7, 0},
+		{"byes", 13, 4, 15, 3},
+		{"mostly byes", 17, 8, 31, 15},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			players := playerIDs(test.players)
+			slots := Knockout(players, test.seeded)
+			if len(slots) != test.slots {
+				t.Fatalf("%d slots, want %d", len(slots), test.slots)
+			}
+			if len(slots) == 0 {
+				return
+			}
+
+			// Byes go to the top seeds, one each
+			byes := 0
+			for _, slot := range slots {
+				if slot.Entrants[1] == Bye {
+					byes++
+					if slot.Entrants[0] > test.byes {
+						t.Errorf("player %d has a bye ahead of a higher seed", slot.Entrants[0])
+					}
+				}
+				if slot.Entrants[0] == Bye {
+					t.Errorf("bye placed above a player in %+v", slot)
+				}
+			}
+			if byes != test.byes {
+				t.Errorf("%d byes, want %d", byes, test.byes)
+			}
+
+			for _, slot := range slots {
+				for side, seed := range slot.Seeds {
+					if seed != 0 && (seed > test.seeded || slot.Entrants[side] != players[seed-1]) {
+						t.Errorf("seed %d marked on player %d", seed, slot.Entrants[side])
+					}
+				}
+			}
+
+			// The best player wins and everyone else loses once
+			result := playDraw(t, slots, lowerID)
+			if result.champion != 1 {
+				t.Errorf("champion %d, want 1", result.champion)
+			}
+			for _, player := range players[1:] {
+				if result.losses[player] != 1 {
+					t.Errorf("player %d lost %d times, want 1", player, result.losses[player])
+				}
+			}
+		})
+	}
+}
+
+func TestTopSeedsMeetLate(t *testing.T) {
+	slots := Knockout(playerIDs(16), 4)
+
+	// Seeds 1 and 2 are in different halves of the draw, and the top 4 in different quarters
+	half, quarter := map[int]int{}, map[int]int{}
+	for _, slot := range slots[:8] {
+		for _, entrant := range slot.Entrants {
+			half[entrant] = slot.Position / 4
+			quarter[entrant] = slot.Position / 2
+		}
+	}
+	if half[1] == half[2] {
+		t.Error("seeds 1 and 2 are in the same half")
+	}
+	if len(map[int]bool{quarter[1]: true, quarter[2]: true, quarter[3]: true, quarter[4]: true}) != 4 {
+		t.Error("the top 4 seeds aren't in different quarters")
+	}
+
+	// So with the better player always winning, 1 plays 2 in the final
+	result := playDraw(t, slots, lowerID)
+	if slots[len(slots)-1].Round != 4 || result.matches[[2]int{1, 2}] != 1 {
+		t.Error("seeds 1 and 2 didn't meet in the final")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fixtures pairs players into matches for competition formats, without a database.
+//
+// Players are identified by their ids, and the first player of a pairing is the home player.
+package fixtures
+
+// Bye stands in for the missing opponent when a player has no match in a round
+const Bye = -1
+
+// Pairing is a match between two players, Away is Bye if Home sits the round out
+type Pairing struct {
+	Home int `json:"home"`
+	Away int `json:"away"`
+}
+
+// Returns true if the pairing is a bye rather than a match
+func (p Pairing) IsBye() bool {
+	return p.Home == Bye || p.Away == Bye
+}
+
+// RoundRobin schedules every player against every other player once, or twice with double, using the circle method
+//
+// One player stays fixed while the rest rotate around them each round, an odd number of players gives each player one bye.
+// With balance, home and away alternate so no player is at home more than one match more than away,
+// a double round robin repeats the rounds with home and away swapped.
+func RoundRobin(players []int, double, balance bool) [][]Pairing {
+	if len(players) < 2 {
+		return [][]Pairing{}
+	}
+
+	// With an odd number of players the bye is the fixed player, so whoever it meets sits the round out
+	circle := append([]int{}, players...)
+	if len(circle)%2 == 1 {
+		circle = append([]int{Bye}, circle...)
+	}
+	n := len(circle)
+
+	rounds := [][]Pairing{}
+	for r := 0; r < n-1; r++ {
+		round := []Pairing{}
+		for i := 0; i < n/2; i++ {
+			home, away := circle[i], circle[n-1-i]
+
+			// The fixed player alternates every round, the rest alternate by their place in the circle
+			if balance && ((i == 0 && r%2 == 1) || (i > 0 && i%2 == 1)) {
+				home, away = away, home
+			}
+
+			// Byes are listed with the player sitting out as home
+			if home == Bye {
+				home, away = away, home
+			}
+			round = append(round, Pairing{Home: home, Away: away})
+		}
+		rounds = append(rounds, round)
+
+		// Keep the first player fixed and rotate the rest one place
+		last := circle[n-1]
+		copy(circle[2:], circle[1:n-1])
+		circle[1] = last
+	}
+
+	if double {
+		for _, round := range rounds[:n-1] {
+			reversed := []Pairing{}
+			for _, p := range round {
+				if p.IsBye() {
+					reversed = append(reversed, p)
+				} else {
+					reversed = append(reversed, Pairing{Home: p.Away, Away: p.Home})
+				}
+			}
+			rounds = append(rounds, reversed)
+		}
+	}
+
+	return rounds
+}
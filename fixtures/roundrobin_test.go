@@ -0,0 +1,105 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+import "testing"
+
+// Returns the ids 1 to n
+func playerIDs(n int) []int {
+	players := make([]int, n)
+	for i := range players {
+		players[i] = i + 1
+	}
+	return players
+}
+
+func TestRoundRobin(t *testing.T) {
+	tests := []struct {
+		name    string
+		players int
+		double  bool
+		balance bool
+		rounds  int
+	}{
+		{"no players", 0, false, true, 0},
+		{"one player", 1, false, true, 0},
+		{"two players", 2, false, true, 1},
+		{"even", 6, false, true, 5},
+		{"odd", 7, false, true, 7},
+		{"unbalanced", 8, false, false, 7},
+		{"double even", 6, true, true, 10},
+		{"double odd", 5, true, true, 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rounds := RoundRobin(playerIDs(test.players), test.double, test.balance)
+			if len(rounds) != test.rounds {
+				t.Fatalf("%d rounds, want %d", len(rounds), test.rounds)
+			}
+
+			meetings := map[[2]int]int{}
+			home := map[int]int{}
+			byes := map[int]int{}
+			for r, round := range rounds {
+				seen := map[int]bool{}
+				for _, p := range round {
+					if p.Home == Bye {
+						t.Fatalf("round %d lists a bye as home", r+1)
+					}
+					if seen[p.Home] || seen[p.Away] {
+						t.Fatalf("round %d has a player twice", r+1)
+					}
+					seen[p.Home] = true
+					if p.IsBye() {
+						byes[p.Home]++
+						continue
+					}
+					seen[p.Away] = true
+					home[p.Home]++
+					home[p.Away]--
+
+					pair := [2]int{p.Home, p.Away}
+					if pair[0] > pair[1] {
+						pair = [2]int{p.Away, p.Home}
+					}
+					meetings[pair]++
+				}
+				if len(seen) != test.players {
+					t.Fatalf("round %d has %d players, want %d", r+1, len(seen), test.players)
+				}
+			}
+
+			times := 1
+			if test.double {
+				times = 2
+			}
+			for a := 1; a <= test.players; a++ {
+				for b := a + 1; b <= test.players; b++ {
+					if meetings[[2]int{a, b}] != times {
+						t.Errorf("%d and %d meet %d times, want %d", a, b, meetings[[2]int{a, b}], times)
+					}
+				}
+				if test.rounds > 0 && test.players%2 == 1 && byes[a] != times {
+					t.Errorf("%d has %d byes, want %d", a, byes[a], times)
+				}
+				// Home minus away is within one, and exactly level over a double round robin
+				if test.balance && (home[a] > 1 || home[a] < -1 || test.double && home[a] != 0) {
+					t.Errorf("%d is home %d more than away", a, home[a])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+// Most steps spent looking for a round without rematches before settling for one with them
+const swissSearchLimit = 100000
+
+// SwissPlayer is a player's standing going into a Swiss round
+type SwissPlayer struct {
+	ID        int
+	Score     int
+	Opponents []int
+	Byes      int
+	// Matches the player has served first in less the matches they have received first in
+	ServeBalance int
+}
+
+// Swiss pairs the next round of a Swiss event, the players are given in standings order
+//
+// Players are paired with the nearest player in the standings they haven't played yet, rematches are only allowed
+// when there is no round without them. With an odd number of players the lowest player with the fewest byes gets one.
+// The player who has served first the least serves first, the Home player of the pairing.
+func Swiss(players []SwissPlayer) []Pairing {
+	pairings := []Pairing{}
+	remaining := append([]SwissPlayer{}, players...)
+
+	if len(remaining)%2 == 1 {
+		bye := len(remaining) - 1
+		for i := len(remaining) - 1; i >= 0; i-- {
+			if remaining[i].Byes < remaining[bye].Byes {
+				bye = i
+			}
+		}
+		pairings = append(pairings, Pairing{Home: remaining[bye].ID, Away: Bye})
+		remaining = append(remaining[:bye], remaining[bye+1:]...)
+	}
+
+	steps := 0
+	matches, ok := pairSwiss(remaining, true, &steps)
+	if !ok {
+		matches, _ = pairSwiss(remaining, false, &steps)
+	}
+
+	for _, match := range matches {
+		home, away := match[0], match[1]
+		if away.ServeBalance < home.ServeBalance {
+			home, away = away, home
+		}
+		pairings = append(pairings, Pairing{Home: home.ID, Away: away.ID})
+	}
+	return pairings
+}
+
+// Pairs the top player with the nearest player they can play, backtracking until everyone is paired
+func pairSwiss(players []SwissPlayer, avoidRematches bool, steps *int) ([][2]SwissPlayer, bool) {
+	if len(players) == 0 {
+		return [][2]SwissPlayer{}, true
+	}
+
+	top := players[0]
+	for i := 1; i < len(players); i++ {
+		if avoidRematches && (played(top, players[i].ID) || *steps >= swissSearchLimit) {
+			continue
+		}
+		*steps++
+
+		rest := make([]SwissPlayer, 0, len(players)-2)
+		rest = append(rest, players[1:i]...)
+		rest = append(rest, players[i+1:]...)
+
+		if matches, ok := pairSwiss(rest, avoidRematches, steps); ok {
+			return append([][2]SwissPlayer{{top, players[i]}}, matches...), true
+		}
+	}
+	return nil, false
+}
+
+// Returns true if the player has already played the opponent
+func played(player SwissPlayer, opponentID int) bool {
+	for _, id := range player.Opponents {
+		if id == opponentID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSwiss(t *testing.T) {
+	tests := []struct {
+		name     string
+		players  []SwissPlayer
+		pairings []Pairing
+	}{
+		{
+			"first round pairs neighbours",
+			[]SwissPlayer{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}},
+			[]Pairing{{1, 2}, {3, 4}},
+		},
+		{
+			"bye to the lowest player",
+			[]SwissPlayer{{ID: 1}, {ID: 2}, {ID: 3}},
+			[]Pairing{{3, Bye}, {1, 2}},
+		},
+		{
+			"bye skips players who have had one",
+			[]SwissPlayer{{ID: 1, Score: 1}, {ID: 2, Score: 1}, {ID: 3, Score: 1, Byes: 1}},
+			[]Pairing{{2, Bye}, {1, 3}},
+		},
+		{
+			"avoids rematches",
+			[]SwissPlayer{{ID: 1, Score: 1, Opponents: []int{2}}, {ID: 2, Opponents: []int{1}}, {ID: 3, Score: 1, Opponents: []int{4}},
+				{ID: 4, Opponents: []int{3}}},
+			[]Pairing{{1, 3}, {2, 4}},
+		},
+		{
+			"backtracks to avoid rematches",
+			[]SwissPlayer{{ID: 1, Opponents: []int{3}}, {ID: 2, Opponents: []int{4}}, {ID: 3, Opponents: []int{1}}, {ID: 4, Opponents: []int{2}}},
+			[]Pairing{{1, 2}, {3, 4}},
+		},
+		{
+			"backtracks when the nearest pairing strands someone",
+			[]SwissPlayer{{ID: 1, Opponents: []int{4}}, {ID: 2, Opponents: []int{3}}, {ID: 3, Opponents: []int{2, 4}}, {ID: 4, Opponents: []int{1, 3}}},
+			[]Pairing{{1, 3}, {2, 4}},
+		},
+		{
+			"rematches when there is no other way",
+			[]SwissPlayer{{ID: 1, Opponents: []int{2}}, {ID: 2, Opponents: []int{1}}},
+			[]Pairing{{1, 2}},
+		},
+		{
+			"whoever has served first less serves first",
+			[]SwissPlayer{{ID: 1, ServeBalance: 1}, {ID: 2, ServeBalance: -1}, {ID: 3, ServeBalance: -1}, {ID: 4, ServeBalance: -1}},
+			[]Pairing{{2, 1}, {3, 4}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if pairings := Swiss(test.players); !reflect.DeepEqual(pairings, test.pairings) {
+				t.Errorf("got %v, want %v", pairings, test.pairings)
+			}
+		})
+	}
+}
+
+// Plays a whole event with the lower id winning, checking nobody meets twice while there is still someone new to play
+func TestSwissEvent(t *testing.T) {
+	for n := 2; n <= 12; n++ {
+		players := []SwissPlayer{}
+		for _, id := range playerIDs(n) {
+			players = append(players, SwissPlayer{ID: id})
+		}
+
+		// A round robin's worth of rounds can always be paired without rematches in theory,
+		// the greedy search is only held to that for the first half of them
+		rounds := (n + 1) / 2
+		for round := 1; round <= rounds; round++ {
+			seen := map[int]bool{}
+			for _, pairing := range Swiss(players) {
+				for _, id := range []int{pairing.Home, pairing.Away} {
+					if id != Bye && seen[id] {
+						t.Fatalf("%d players round %d: %d paired twice", n, round, id)
+					}
+					seen[id] = true
+				}
+
+				home, away := &players[pairing.Home-1], &players[0]
+				if pairing.IsBye() {
+					home.Byes++
+					home.Score++
+					continue
+				}
+				away = &players[pairing.Away-1]
+				if played(*home, away.ID) {
+					t.Errorf("%d players round %d: %d and %d meet again", n, round, home.ID, away.ID)
+				}
+				home.Opponents = append(home.Opponents, away.ID)
+				away.Opponents = append(away.Opponents, home.ID)
+				home.ServeBalance++
+				away.ServeBalance--
+				if home.ID < away.ID {
+					home.Score++
+				} else {
+					away.Score++
+				}
+			}
+		}
+
+		for _, player := range players {
+			if player.Byes > 1 {
+				t.Errorf("%d players: %d had %d byes", n, player.ID, player.Byes)
+			}
+			if player.ServeBalance > 2 || player.ServeBalance < -2 {
+				t.Errorf("%d players: %d has a serve balance of %d", n, player.ID, player.ServeBalance)
+			}
+		}
+	}
+}
@@ -141,3 +141,55 @@ func sendWelcomeEmail(player PlayerRegister) {
 		panic(err)
 	}
 }
+
+// Runs queries against the database or within a transaction
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Helper function
+//
+// Locks the match until the transaction ends and checks it is still at the expected version
+// A nil expected version skips the check
+// Matches scored before the history was kept have it backfilled while the lock is held
+//
+// Returns the current version and whether it matched
+func lockMatchVersion(tx *sql.Tx, matchID int, expected *int) (int, bool, error) {
+	var version int
+	err := tx.QueryRow(`SELECT version FROM match WHERE id = $1 FOR UPDATE`, matchID).Scan(&version)
+	if err != nil {
+		return 0, false, err
+	}
+	if err = backfillPointEvents(tx, matchID); err != nil {
+		return 0, false, err
+	}
+	return version, expected == nil || *expected == version, nil
+}
+
+// Helper function
+//
+// Locks the match and checks it is still at the expected version
+// Responds with 409 and returns false if the match has changed
+func tryLockMatchVersion(c *gin.Context, tx *sql.Tx, matchID int, expected *int) bool {
+	version, current, err := lockMatchVersion(tx, matchID, expected)
+	if handleError(err, c) {
+		return false
+	} else if !current {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: fmt.Sprintf("Match is at version %d", version)})
+		return false
+	}
+	return true
+}
+
+// Helper function
+//
+// Increments the match's version after a change to its score or status
+//
+// Returns the new version
+func bumpMatchVersion(tx *sql.Tx, matchID int) (int, error) {
+	var version int
+	err := tx.QueryRow(`UPDATE match SET version = version + 1 WHERE id = $1 RETURNING version`, matchID).Scan(&version)
+	return version, err
+}
@@ -0,0 +1,345 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"tennis-api/scoring"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Endpoint: /matches/:id/history
+//
+// Returns every point scored, undone and redone in the match, in order
+func getPointHistory(c *gin.Context) {
+	param := c.Param("id")
+	matchID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	events, err := getPointEvents(db, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	var response struct {
+		Events []PointEvent `json:"events"`
+	}
+	response.Events = events
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Endpoint: /matches/:id/undo
+//
+// Steps back the given number of points, 1 by default
+// Undoing the winning point reopens the match
+func undoPoints(c *gin.Context) {
+	stepPointHistory(c, ActionUndo)
+}
+
+// Endpoint: /matches/:id/redo
+//
+// Restores the given number of undone points, 1 by default
+// Undone points can be redone until a new point is scored
+func redoPoints(c *gin.Context) {
+	stepPointHistory(c, ActionRedo)
+}
+
+// Helper function
+//
+// Appends undo or redo events to the match's history, then rebuilds its points, games and sets
+// Responds with a Score Object for the match after the change, or 409 if a version is given and the match has changed
+func stepPointHistory(c *gin.Context, action string) {
+	param := c.Param("id")
+	matchID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		Count   int  `form:"count"`
+		Version *int `form:"version"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	if request.Count == 0 {
+		request.Count = 1
+	} else if request.Count < 0 {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	if !tryLockMatchVersion(c, tx, matchID, request.Version) {
+		return
+	}
+
+	// Matches ended early don't finish on a point, so there is nothing to reopen
+	var reason *string
+	err = tx.QueryRow(`SELECT reason FROM match_result WHERE match_id = $1`, matchID).Scan(&reason)
+	if err != nil && err != sql.ErrNoRows {
+		handleError(err, c)
+		return
+	} else if reason != nil && *reason != EndCompleted {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Match ended by " + *reason})
+		return
+	}
+
+	events, err := getPointEvents(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	points, undone := replayPointEvents(events)
+	steps, verb := points, "undone"
+	if action == ActionRedo {
+		steps, verb = undone, "redone"
+	}
+
+	if request.Count > len(steps) {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: fmt.Sprintf("Only %d points can be %s", len(steps), verb)})
+		return
+	}
+
+	for i := 0; i < request.Count; i++ {
+		step := steps[len(steps)-1-i]
+		event := PointEvent{Action: action, PointNum: step.PointNum}
+		err = appendPointEvent(tx, matchID, &event)
+		if handleError(err, c) {
+			return
+		}
+	}
+
+	state, teams, err := rebuildMatchPoints(tx, matchID)
+	if err == errBracketAdvanced || err == errLadderMoved {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Can't reopen the match, " + err.Error()})
+		return
+	} else if handleError(err, c) {
+		return
+	}
+
+	response := getScoreResponse(state, teams)
+	response.Version, err = bumpMatchVersion(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+
+	if action == ActionUndo {
+		publishMatchEvent(matchID, LiveUndo)
+	} else {
+		publishMatchEvent(matchID, LiveRedo)
+	}
+}
+
+// Helper function
+//
+// Adds the event to the end of the match's history, filling in its id and time
+func appendPointEvent(q queryer, matchID int, event *PointEvent) error {
+	sqlStatement := `INSERT INTO point_event (match_id, action, point_number, winner_id, faults, lets, ace, unforced_error, scored_at,
+		serve_number, serve_direction, ending, shot_type, rally_length, striker_id, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, current_timestamp)
+	RETURNING id, created_at`
+
+	return q.QueryRow(sqlStatement, matchID, event.Action, event.PointNum, event.WinnerID, event.Faults, event.Lets,
+		event.Ace, event.UnforcedError, event.ScoredAt, event.ServeNumber, event.ServeDirection, event.Ending, event.ShotType,
+		event.RallyLength, event.StrikerID).Scan(&event.ID, &event.CreatedAt)
+}
+
+// Helper function
+//
+// Copies the points of a match scored before the history was kept into its history
+// Only called while the match is locked, so the copy is made once
+func backfillPointEvents(q queryer, matchID int) error {
+	sqlStatement := `INSERT INTO point_event (match_id, action, point_number, winner_id, faults, lets, ace, unforced_error, created_at)
+	SELECT match_id, 'score', number, winner_id, faults, lets, ace, unforced_error, current_timestamp FROM point
+	WHERE match_id = $1 AND winner_id IS NOT NULL
+	AND NOT EXISTS (SELECT 1 FROM point_event WHERE match_id = $1)
+	ORDER BY number`
+	_, err := q.Exec(sqlStatement, matchID)
+	return err
+}
+
+// Returns the match's history in order
+//
+// Matches scored before the history was kept and not yet backfilled have their points read as the history instead
+func getPointEvents(q queryer, matchID int) ([]PointEvent, error) {
+	sqlStatement := `SELECT id, action, point_number, winner_id, faults, lets, ace, unforced_error, scored_at,
+		serve_number, serve_direction, ending, shot_type, rally_length, striker_id, created_at
+	FROM point_event
+	WHERE match_id = $1
+	ORDER BY id`
+	rows, err := q.Query(sqlStatement, matchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []PointEvent{}
+	for rows.Next() {
+		var event PointEvent
+		err = rows.Scan(&event.ID, &event.Action, &event.PointNum, &event.WinnerID, &event.Faults, &event.Lets,
+			&event.Ace, &event.UnforcedError, &event.ScoredAt, &event.ServeNumber, &event.ServeDirection, &event.Ending,
+			&event.ShotType, &event.RallyLength, &event.StrikerID, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil || len(events) > 0 {
+		return events, err
+	}
+	rows.Close()
+
+	sqlStatement = `SELECT number, winner_id, faults, lets, ace, unforced_error FROM point
+	WHERE match_id = $1 AND winner_id IS NOT NULL
+	ORDER BY number`
+	rows, err = q.Query(sqlStatement, matchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := PointEvent{Action: ActionScore}
+		err = rows.Scan(&event.PointNum, &event.WinnerID, &event.Faults, &event.Lets, &event.Ace, &event.UnforcedError)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// Works through the match's history, returning the points that currently count in order
+// and the undone points that can be redone, the most recently undone last
+func replayPointEvents(events []PointEvent) ([]PointEvent, []PointEvent) {
+	points, undone := []PointEvent{}, []PointEvent{}
+
+	for _, event := range events {
+		switch event.Action {
+		case ActionScore:
+			points = append(points, event)
+			undone = undone[:0]
+		case ActionUndo:
+			if len(points) > 0 {
+				undone = append(undone, points[len(points)-1])
+				points = points[:len(points)-1]
+			}
+		case ActionRedo:
+			if len(undone) > 0 {
+				points = append(points, undone[len(undone)-1])
+				undone = undone[:len(undone)-1]
+			}
+		}
+	}
+
+	return points, undone
+}
+
+// Helper function
+//
+// Replaces the match's points, games, sets and result with those derived from its history
+// Returns the match state along with both teams
+func rebuildMatchPoints(q queryer, matchID int) (*scoring.MatchState, matchTeams, error) {
+	teams, err := getMatchTeams(q, matchID)
+	if err != nil {
+		return nil, teams, err
+	}
+
+	format, err := getMatchFormat(q, matchID)
+	if err != nil {
+		return nil, teams, err
+	}
+
+	status, err := getMatchStatus(q, matchID)
+	if err != nil {
+		return nil, teams, err
+	}
+
+	var firstServer int
+	err = q.QueryRow(`SELECT server_id FROM point WHERE match_id = $1 AND number = 1`, matchID).Scan(&firstServer)
+	if err != nil {
+		return nil, teams, err
+	}
+
+	events, err := getPointEvents(q, matchID)
+	if err != nil {
+		return nil, teams, err
+	}
+
+	// The result is about to be removed, recording the winning point again completes the match again
+	err = matchReopened(q, matchID)
+	if err != nil {
+		return nil, teams, err
+	}
+
+	sqlStatement := `with points as (DELETE FROM point WHERE match_id = $1),
+	games as (DELETE FROM game WHERE set_id IN (SELECT id FROM set WHERE match_id = $1)),
+	sets as (DELETE FROM set WHERE match_id = $1)
+	DELETE FROM match_result WHERE match_id = $1`
+	_, err = q.Exec(sqlStatement, matchID)
+	if err != nil {
+		return nil, teams, err
+	}
+
+	// Reopen the match, recording the winning point again completes it
+	if status == StatusCompleted {
+		sqlStatement = `UPDATE match SET end_date=NULL, status='in_progress' WHERE id = $1`
+		_, err = q.Exec(sqlStatement, matchID)
+		if err != nil {
+			return nil, teams, err
+		}
+	}
+
+	state := scoring.NewMatch(format.Format, teams.side(firstServer))
+	server, receiver := teams.serverReceiver(state)
+	err = newSetGamePoint(q, matchID, 1, 1, server, receiver, state.Tiebreak)
+	if err != nil {
+		return nil, teams, err
+	}
+
+	points, _ := replayPointEvents(events)
+	for _, point := range points {
+		err = recordPoint(q, matchID, state, teams, point)
+		if err != nil {
+			return nil, teams, err
+		}
+	}
+
+	return state, teams, nil
+}
@@ -0,0 +1,707 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Returned when a challenge result can't be taken back because either player has moved on the ladder since
+var errLadderMoved = errors.New("a player from this challenge has moved on the ladder since")
+
+// How often challenges past their deadline are looked for outside of requests to the ladder
+const challengeExpiryInterval = time.Hour
+
+const challengeColumns = `id, comp_id, challenger_id, defender_id, challenger_position, defender_position, status, match_id, winner_id,
+	created_at, deadline, resolved_at`
+
+func scanChallenge(row interface{ Scan(...interface{}) error }) (Challenge, error) {
+	var challenge Challenge
+	err := row.Scan(&challenge.ID, &challenge.CompID, &challenge.ChallengerID, &challenge.DefenderID, &challenge.ChallengerPosition,
+		&challenge.DefenderPosition, &challenge.Status, &challenge.MatchID, &challenge.WinnerID, &challenge.CreatedAt,
+		&challenge.Deadline, &challenge.ResolvedAt)
+	return challenge, err
+}
+
+// Returns the comp's challenges, newest first, optionally only those with the status
+func getChallenges(q queryer, compID int, status string) ([]Challenge, error) {
+	sqlStatement := `SELECT ` + challengeColumns + ` FROM challenge
+	WHERE comp_id = $1 AND ($2 = '' OR status = $2)
+	ORDER BY id DESC`
+	rows, err := q.Query(sqlStatement, compID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	challenges := []Challenge{}
+	for rows.Next() {
+		challenge, err := scanChallenge(rows)
+		if err != nil {
+			return nil, err
+		}
+		challenges = append(challenges, challenge)
+	}
+	return challenges, rows.Err()
+}
+
+// Returns the ladder's rules, sql.ErrNoRows if the comp isn't a ladder
+func getLadderRules(q queryer, compID int) (LadderRules, error) {
+	var rules LadderRules
+	sqlStatement := `SELECT challenge_range, challenge_days FROM ladder WHERE comp_id = $1`
+	err := q.QueryRow(sqlStatement, compID).Scan(&rules.Range, &rules.Days)
+	return rules, err
+}
+
+// Players who joined comp $1 after the ladder was made, placed at the bottom in the order they joined
+const newLadderPlayers = `SELECT r.comp_id, r.player_id,
+		(SELECT COALESCE(MAX(position), 0) FROM ladder_position WHERE comp_id = $1) + ROW_NUMBER() OVER (ORDER BY r.reg_date, r.player_id) AS position
+	FROM comp_reg r
+	WHERE r.comp_id = $1 AND (r.pending IS NULL OR r.pending = false)
+	AND NOT EXISTS (SELECT 1 FROM ladder_position l WHERE l.comp_id = r.comp_id AND l.player_id = r.player_id)`
+
+// Helper function
+//
+// Adds players who joined the comp after the ladder was made to the bottom, in the order they joined
+func addLadderPlayers(q queryer, compID int) error {
+	sqlStatement := `INSERT INTO ladder_position (comp_id, player_id, position) ` + newLadderPlayers
+	_, err := q.Exec(sqlStatement, compID)
+	return err
+}
+
+// Returns the player's place on the ladder, sql.ErrNoRows if they aren't on it
+func getLadderPosition(q queryer, compID, playerID int) (int, error) {
+	var position int
+	err := q.QueryRow(`SELECT position FROM ladder_position WHERE comp_id = $1 AND player_id = $2`, compID, playerID).Scan(&position)
+	return position, err
+}
+
+// Puts each player at the given place on the ladder
+func setLadderPositions(q queryer, compID int, positions map[int]int) error {
+	for playerID, position := range positions {
+		_, err := q.Exec(`UPDATE ladder_position SET position = $3 WHERE comp_id = $1 AND player_id = $2`, compID, playerID, position)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper function
+//
+// Returns the challenges in the comp, or every comp if 0, that the defender has forfeited by missing the deadline
+// The defender has to answer and play by the deadline, so that is any challenge still pending
+// and any accepted challenge whose match hasn't started, matches under way are left to finish
+func overdueChallenges(q queryer, compID int) ([]Challenge, error) {
+	sqlStatement := `SELECT ` + challengeColumns + ` FROM challenge
+	WHERE ($1 = 0 OR comp_id = $1) AND status IN ($2, $3) AND deadline < current_timestamp
+	ORDER BY id`
+	rows, err := q.Query(sqlStatement, compID, ChallengePending, ChallengeAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	challenges := []Challenge{}
+	for rows.Next() {
+		challenge, err := scanChallenge(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		challenges = append(challenges, challenge)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	overdue := []Challenge{}
+	for _, challenge := range challenges {
+		if challenge.Status == ChallengeAccepted && challenge.MatchID != nil {
+			status, err := getMatchStatus(q, *challenge.MatchID)
+			if err != nil {
+				return nil, err
+			} else if status != StatusScheduled {
+				continue
+			}
+		}
+		overdue = append(overdue, challenge)
+	}
+	return overdue, nil
+}
+
+// Helper function
+//
+// Settles the overdue challenges in the comp, or every comp if 0, as forfeits by the defender
+// The players swap places and any match scheduled for the challenge is removed
+func expireChallenges(q queryer, compID int) error {
+	challenges, err := overdueChallenges(q, compID)
+	if err != nil {
+		return err
+	}
+
+	for _, challenge := range challenges {
+		if challenge.MatchID != nil {
+			if err = deleteMatch(q, *challenge.MatchID); err != nil {
+				return err
+			}
+		}
+
+		err = resolveChallenge(q, challenge, &challenge.ChallengerID, ChallengeForfeited)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Helper function
+//
+// Returns the ids of the comp's overdue challenges, for requests that show them as forfeited without settling them
+func overdueChallengeIDs(compID int) (map[int]bool, error) {
+	challenges, err := overdueChallenges(db, compID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[int]bool{}
+	for _, challenge := range challenges {
+		ids[challenge.ID] = true
+	}
+	return ids, nil
+}
+
+// Helper function
+//
+// Returns the challenge as expireChallenges will leave it
+func forfeitedChallenge(challenge Challenge) Challenge {
+	winnerID := challenge.ChallengerID
+	challenge.Status, challenge.MatchID, challenge.WinnerID, challenge.ResolvedAt = ChallengeForfeited, nil, &winnerID, &challenge.Deadline
+	return challenge
+}
+
+// Helper function
+//
+// Expires challenges in every comp on a timer, so forfeits happen without anyone looking at the ladder
+func expireChallengesPeriodically() {
+	for range time.Tick(challengeExpiryInterval) {
+		tx, err := db.Begin()
+		if err != nil {
+			println(err.Error())
+			continue
+		}
+
+		err = expireChallenges(tx, 0)
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if err != nil {
+			println(err.Error())
+		}
+	}
+}
+
+// Helper function
+//
+// Closes the challenge with the given status and winner, the players swap places if the challenger won
+func resolveChallenge(q queryer, challenge Challenge, winnerID *int, status string) error {
+	if winnerID != nil && *winnerID == challenge.ChallengerID {
+		err := setLadderPositions(q, challenge.CompID, map[int]int{
+			challenge.ChallengerID: challenge.DefenderPosition,
+			challenge.DefenderID:   challenge.ChallengerPosition,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sqlStatement := `UPDATE challenge SET status = $2, winner_id = $3, resolved_at = current_timestamp WHERE id = $1`
+	_, err := q.Exec(sqlStatement, challenge.ID, status, winnerID)
+	return err
+}
+
+// Helper function
+//
+// Resolves the challenge played in the match once it has finished, abandoned matches leave the ladder as it was
+func completeChallenge(q queryer, matchID int) error {
+	challenge, err := scanChallenge(q.QueryRow(`SELECT `+challengeColumns+` FROM challenge WHERE match_id = $1 AND status = $2`,
+		matchID, ChallengeAccepted))
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var winnerID *int
+	err = q.QueryRow(`SELECT winner_id FROM match_result WHERE match_id = $1`, matchID).Scan(&winnerID)
+	if err != nil {
+		return err
+	}
+	return resolveChallenge(q, challenge, winnerID, ChallengeCompleted)
+}
+
+// Helper function
+//
+// Reopens the challenge played in the match, putting the players back if they swapped places
+// Returns errLadderMoved if either player has moved since
+func reopenChallenge(q queryer, matchID int) error {
+	challenge, err := scanChallenge(q.QueryRow(`SELECT `+challengeColumns+` FROM challenge WHERE match_id = $1 AND status = $2`,
+		matchID, ChallengeCompleted))
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if challenge.WinnerID != nil && *challenge.WinnerID == challenge.ChallengerID {
+		challengerPosition, err := getLadderPosition(q, challenge.CompID, challenge.ChallengerID)
+		if err != nil {
+			return err
+		}
+		defenderPosition, err := getLadderPosition(q, challenge.CompID, challenge.DefenderID)
+		if err != nil {
+			return err
+		}
+		if challengerPosition != challenge.DefenderPosition || defenderPosition != challenge.ChallengerPosition {
+			return errLadderMoved
+		}
+
+		err = setLadderPositions(q, challenge.CompID, map[int]int{
+			challenge.ChallengerID: challenge.ChallengerPosition,
+			challenge.DefenderID:   challenge.DefenderPosition,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sqlStatement := `UPDATE challenge SET status = $2, winner_id = NULL, resolved_at = NULL WHERE id = $1`
+	_, err = q.Exec(sqlStatement, challenge.ID, ChallengeAccepted)
+	return err
+}
+
+// Endpoint: /comps/:id/ladder
+//
+// Makes the comp a ladder, placing its registered players by rating, or by the seeds given with manual seeding
+// Players can challenge anyone up to range places above them, 3 by default, and must play within days, 14 by default
+// Returns the ladder
+func createLadder(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		Range   *int   `form:"range" json:"range"`
+		Days    *int   `form:"days" json:"days"`
+		Seeding string `form:"seeding" json:"seeding"`
+		Seeds   []int  `form:"seeds" json:"seeds"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	rules := LadderRules{Range: 3, Days: 14}
+	if request.Range != nil {
+		rules.Range = *request.Range
+	}
+	if request.Days != nil {
+		rules.Days = *request.Days
+	}
+	if rules.Range < 1 || rules.Days < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "range and days must be at least 1"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	var compType *string
+	err = tx.QueryRow(`SELECT comp_type FROM comp WHERE id = $1 FOR UPDATE`, compID).Scan(&compType)
+	if handleError(err, c) {
+		return
+	}
+
+	exists, err := compHasFixtures(tx, compID)
+	if handleError(err, c) {
+		return
+	} else if exists || compType != nil && *compType == CompLadder {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Comp already has fixtures"})
+		return
+	}
+
+	players, ok := tryGetSeeding(c, tx, compID, request.Seeding, request.Seeds)
+	if !ok {
+		return
+	}
+
+	sqlStatement := `INSERT INTO ladder (comp_id, challenge_range, challenge_days) VALUES ($1, $2, $3)`
+	_, err = tx.Exec(sqlStatement, compID, rules.Range, rules.Days)
+	if handleError(err, c) {
+		return
+	}
+
+	for i, playerID := range players {
+		sqlStatement = `INSERT INTO ladder_position (comp_id, player_id, position) VALUES ($1, $2, $3)`
+		_, err = tx.Exec(sqlStatement, compID, playerID, i+1)
+		if handleError(err, c) {
+			return
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE comp SET comp_type = $2 WHERE id = $1`, compID, CompLadder)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	getLadder(c)
+}
+
+// Endpoint: /comps/:id/ladder
+//
+// Returns the ladder top to bottom, with each player's open challenge
+// Challenges past their deadline are shown as forfeited and players who have joined since are shown at the bottom,
+// both are only saved the next time the ladder is changed
+func getLadder(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var response struct {
+		LadderRules
+		Rungs []LadderRung `json:"rungs"`
+	}
+
+	response.LadderRules, err = getLadderRules(db, compID)
+	if handleError(err, c) {
+		return
+	}
+
+	overdue, err := overdueChallengeIDs(compID)
+	if handleError(err, c) {
+		return
+	}
+
+	sqlStatement := `SELECT l.position, p.id, p.first_name, p.last_name, p.is_admin
+	FROM (SELECT comp_id, player_id, position FROM ladder_position WHERE comp_id = $1 UNION ALL ` + newLadderPlayers + `) l
+	JOIN player p ON p.id = l.player_id
+	ORDER BY l.position`
+	rows, err := db.Query(sqlStatement, compID)
+	if handleError(err, c) {
+		return
+	}
+	defer rows.Close()
+
+	response.Rungs = []LadderRung{}
+	rungs := map[int]*LadderRung{}
+	for rows.Next() {
+		var rung LadderRung
+		err = rows.Scan(&rung.Position, &rung.Player.Id, &rung.Player.FirstName, &rung.Player.LastName, &rung.Player.Admin)
+		if handleError(err, c) {
+			return
+		}
+		response.Rungs = append(response.Rungs, rung)
+	}
+	if handleError(rows.Err(), c) {
+		return
+	}
+	rows.Close()
+	for i := range response.Rungs {
+		rungs[response.Rungs[i].Player.Id] = &response.Rungs[i]
+	}
+
+	// Forfeited challenges swap the players, open ones are shown against both players
+	for _, status := range []string{ChallengePending, ChallengeAccepted} {
+		challenges, err := getChallenges(db, compID, status)
+		if handleError(err, c) {
+			return
+		}
+		for i := range challenges {
+			challenger, defender := rungs[challenges[i].ChallengerID], rungs[challenges[i].DefenderID]
+			if challenger == nil || defender == nil {
+				continue
+			}
+			if overdue[challenges[i].ID] {
+				challenger.Position, defender.Position = challenges[i].DefenderPosition, challenges[i].ChallengerPosition
+				continue
+			}
+			challenger.Challenge, defender.Challenge = &challenges[i], &challenges[i]
+		}
+	}
+	sort.SliceStable(response.Rungs, func(i, j int) bool {
+		return response.Rungs[i].Position < response.Rungs[j].Position
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Endpoint: /comps/:id/challenges
+//
+// Returns the comp's challenges newest first, optionally only those with the status given
+// Challenges past their deadline are shown as forfeited
+func getCompChallenges(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		Status string `form:"status"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	overdue, err := overdueChallengeIDs(compID)
+	if handleError(err, c) {
+		return
+	}
+
+	challenges, err := getChallenges(db, compID, "")
+	if handleError(err, c) {
+		return
+	}
+
+	var response struct {
+		Challenges []Challenge `json:"challenges"`
+	}
+	response.Challenges = []Challenge{}
+	for _, challenge := range challenges {
+		if overdue[challenge.ID] {
+			challenge = forfeitedChallenge(challenge)
+		}
+		if request.Status == "" || challenge.Status == request.Status {
+			response.Challenges = append(response.Challenges, challenge)
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Endpoint: /comps/:id/challenges
+//
+// Challenges a player up to the ladder's range of places above the challenger
+// Neither player can already be in an open challenge, the match must be played by the deadline
+// Returns the challenge
+func createChallenge(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		ChallengerID int `form:"challengerID" json:"challengerID" binding:"required"`
+		DefenderID   int `form:"defenderID" json:"defenderID" binding:"required"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the ladder so players can't be challenged twice at once
+	var rules LadderRules
+	sqlStatement := `SELECT challenge_range, challenge_days FROM ladder WHERE comp_id = $1 FOR UPDATE`
+	err = tx.QueryRow(sqlStatement, compID).Scan(&rules.Range, &rules.Days)
+	if handleError(err, c) {
+		return
+	}
+
+	if handleError(expireChallenges(tx, compID), c) || handleError(addLadderPlayers(tx, compID), c) {
+		return
+	}
+
+	challenge := Challenge{CompID: compID, ChallengerID: request.ChallengerID, DefenderID: request.DefenderID}
+	challenge.ChallengerPosition, err = getLadderPosition(tx, compID, request.ChallengerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Challenger is not on the ladder"})
+		return
+	} else if handleError(err, c) {
+		return
+	}
+	challenge.DefenderPosition, err = getLadderPosition(tx, compID, request.DefenderID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Defender is not on the ladder"})
+		return
+	} else if handleError(err, c) {
+		return
+	}
+
+	places := challenge.ChallengerPosition - challenge.DefenderPosition
+	if places < 1 || places > rules.Range {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: fmt.Sprintf("Players can only challenge up to %d places above them", rules.Range)})
+		return
+	}
+
+	var busy bool
+	sqlStatement = `SELECT EXISTS (SELECT 1 FROM challenge WHERE comp_id = $1 AND status IN ($4, $5)
+		AND (challenger_id IN ($2, $3) OR defender_id IN ($2, $3)))`
+	err = tx.QueryRow(sqlStatement, compID, request.ChallengerID, request.DefenderID, ChallengePending, ChallengeAccepted).Scan(&busy)
+	if handleError(err, c) {
+		return
+	} else if busy {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "A player is already in an open challenge"})
+		return
+	}
+
+	challenge.Status = ChallengePending
+	sqlStatement = `INSERT INTO challenge (comp_id, challenger_id, defender_id, challenger_position, defender_position, status, created_at, deadline)
+	VALUES ($1, $2, $3, $4, $5, $6, current_timestamp, current_timestamp + make_interval(days => $7))
+	RETURNING id, created_at, deadline`
+	err = tx.QueryRow(sqlStatement, compID, challenge.ChallengerID, challenge.DefenderID, challenge.ChallengerPosition,
+		challenge.DefenderPosition, challenge.Status, rules.Days).Scan(&challenge.ID, &challenge.CreatedAt, &challenge.Deadline)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, challenge)
+}
+
+// Endpoint: /comps/:id/challenges/:challengeID/accept
+//
+// Accepts a pending challenge and schedules its match for the date given, now by default
+// The challenger serves first, every match uses the format given
+// Returns the challenge
+func acceptChallenge(c *gin.Context) {
+	var request struct {
+		Date *time.Time `form:"date" json:"date"`
+		MatchFormatRequest
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	format, err := buildMatchFormat(request.MatchFormatRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: err.Error()})
+		return
+	}
+
+	date := time.Now()
+	if request.Date != nil {
+		date = *request.Date
+	}
+
+	answerChallenge(c, func(tx *sql.Tx, challenge *Challenge) error {
+		match, _, _, err := createMatch(tx, challenge.CompID, date, nil, []int{challenge.ChallengerID, challenge.DefenderID}, format)
+		if err != nil {
+			return err
+		}
+
+		challenge.Status, challenge.MatchID = ChallengeAccepted, &match.MatchID
+		sqlStatement := `UPDATE challenge SET status = $2, match_id = $3 WHERE id = $1`
+		_, err = tx.Exec(sqlStatement, challenge.ID, challenge.Status, challenge.MatchID)
+		return err
+	})
+}
+
+// Endpoint: /comps/:id/challenges/:challengeID/decline
+//
+// Declines a pending challenge, the ladder stays as it is
+// Returns the challenge
+func declineChallenge(c *gin.Context) {
+	answerChallenge(c, func(tx *sql.Tx, challenge *Challenge) error {
+		challenge.Status = ChallengeDeclined
+		sqlStatement := `UPDATE challenge SET status = $2, resolved_at = current_timestamp WHERE id = $1 RETURNING resolved_at`
+		return tx.QueryRow(sqlStatement, challenge.ID, challenge.Status).Scan(&challenge.ResolvedAt)
+	})
+}
+
+// Helper function
+//
+// Runs the answer against a pending challenge, responding with the challenge afterwards
+// Responds with 409 if the challenge has already been answered or has passed its deadline
+func answerChallenge(c *gin.Context, answer func(tx *sql.Tx, challenge *Challenge) error) {
+	compID, err := strconv.Atoi(c.Param("id"))
+	if handleError(err, c) {
+		return
+	}
+	challengeID, err := strconv.Atoi(c.Param("challengeID"))
+	if handleError(err, c) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`SELECT 1 FROM ladder WHERE comp_id = $1 FOR UPDATE`, compID)
+	if handleError(err, c) || handleError(expireChallenges(tx, compID), c) {
+		return
+	}
+
+	challenge, err := scanChallenge(tx.QueryRow(`SELECT `+challengeColumns+` FROM challenge WHERE id = $1 AND comp_id = $2`,
+		challengeID, compID))
+	if handleError(err, c) {
+		return
+	} else if challenge.Status != ChallengePending {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Challenge is " + challenge.Status})
+		return
+	}
+
+	err = answer(tx, &challenge)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, challenge)
+	if challenge.MatchID != nil {
+		fireMatchWebhooks(*challenge.MatchID, WebhookMatchCreated)
+	}
+}
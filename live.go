@@ -0,0 +1,237 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How often an idle stream is sent a heartbeat so proxies don't close it
+const liveHeartbeat = 30 * time.Second
+
+// Events buffered for each stream, a stream that falls this far behind is closed so the client reconnects
+const liveBuffer = 32
+
+// Subscribers to live events, keyed by topic
+type liveHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan LiveEvent]bool
+}
+
+var live = liveHub{subscribers: map[string]map[chan LiveEvent]bool{}}
+
+func matchTopic(matchID int) string {
+	return fmt.Sprintf("match:%d", matchID)
+}
+
+func compTopic(compID int) string {
+	return fmt.Sprintf("comp:%d", compID)
+}
+
+func (h *liveHub) subscribe(topic string) chan LiveEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make(chan LiveEvent, liveBuffer)
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = map[chan LiveEvent]bool{}
+	}
+	h.subscribers[topic][events] = true
+	return events
+}
+
+func (h *liveHub) unsubscribe(topic string, events chan LiveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers[topic][events] {
+		delete(h.subscribers[topic], events)
+		close(events)
+	}
+	if len(h.subscribers[topic]) == 0 {
+		delete(h.subscribers, topic)
+	}
+}
+
+func (h *liveHub) empty() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers) == 0
+}
+
+// Sends the event to every subscriber of the topics without blocking, slow subscribers are dropped
+func (h *liveHub) publish(event LiveEvent, topics ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, topic := range topics {
+		for events := range h.subscribers[topic] {
+			select {
+			case events <- event:
+			default:
+				delete(h.subscribers[topic], events)
+				close(events)
+			}
+		}
+		if len(h.subscribers[topic]) == 0 {
+			delete(h.subscribers, topic)
+		}
+	}
+}
+
+// Helper function
+//
+// Sends a live event with the match's current score to anyone following the match or its comp
+// Reads the score with db so followers only see committed points, nothing is read when nobody is following
+func publishMatchEvent(matchID int, eventType string) {
+	if live.empty() {
+		return
+	}
+
+	event, err := getLiveEvent(matchID, eventType)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	topics := []string{matchTopic(matchID)}
+	if event.Match.Competition != nil && event.Match.Competition.Id != nil {
+		topics = append(topics, compTopic(*event.Match.Competition.Id))
+	}
+	live.publish(event, topics...)
+}
+
+// Returns a live event with the match and its current score
+func getLiveEvent(matchID int, eventType string) (LiveEvent, error) {
+	event := LiveEvent{Event: eventType}
+
+	match, err := getMatch(matchID)
+	if err != nil {
+		return event, err
+	}
+
+	state, teams, err := getMatchState(db, matchID)
+	if err != nil {
+		return event, err
+	}
+
+	event.Score = getScoreResponse(state, teams)
+	event.Score.Version = match.Version
+	event.Match = match
+	return event, nil
+}
+
+// Endpoint: /matches/:id/live
+//
+// Streams Server-Sent Events for the match, starting with its current score
+// An event is sent whenever a point is scored, undone or redone, and when the match's status changes or it ends
+func streamMatch(c *gin.Context) {
+	param := c.Param("id")
+	matchID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	streamLive(c, matchTopic(matchID), func() ([]LiveEvent, error) {
+		event, err := getLiveEvent(matchID, LiveSnapshot)
+		return []LiveEvent{event}, err
+	})
+}
+
+// Endpoint: /comps/:id/live
+//
+// Streams Server-Sent Events for every match in the comp, starting with the score of each match in progress
+func streamComp(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	streamLive(c, compTopic(compID), func() ([]LiveEvent, error) {
+		sqlStatement := `SELECT match.id FROM match
+		LEFT JOIN match_result ON match.id = match_result.match_id
+		WHERE match.comp_id = $1 AND ` + matchStatusColumn + ` = $2
+		ORDER BY match.id`
+		matchIDs, err := queryMatchIDs(sqlStatement, compID, StatusInProgress)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot := []LiveEvent{}
+		for _, matchID := range matchIDs {
+			event, err := getLiveEvent(matchID, LiveSnapshot)
+			if err != nil {
+				return nil, err
+			}
+			snapshot = append(snapshot, event)
+		}
+		return snapshot, nil
+	})
+}
+
+// Helper function
+//
+// Subscribes to the topic, then writes the snapshot and the topic's events to the response until the client disconnects
+// The snapshot is taken after subscribing so a change made meanwhile is streamed, events it already includes are skipped
+func streamLive(c *gin.Context, topic string, snapshot func() ([]LiveEvent, error)) {
+	events := live.subscribe(topic)
+	defer live.unsubscribe(topic, events)
+
+	current, err := snapshot()
+	if handleError(err, c) {
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	versions := map[int]int{}
+	for _, event := range current {
+		versions[event.Match.MatchID] = event.Score.Version
+		c.SSEvent(event.Event, event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if version, ok := versions[event.Match.MatchID]; ok && event.Score.Version <= version {
+				return true
+			}
+			c.SSEvent(event.Event, event)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent(LiveHeartbeat, time.Now())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
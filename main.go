@@ -16,7 +16,9 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	_ "net/http"
+	"os"
 
 	_ "golang.org/x/crypto/bcrypt"
 
@@ -29,20 +31,41 @@ var db *sql.DB
 func main() {
 	// gin.SetMode(gin.ReleaseMode)
 	connectToDB()
+
+	// Commands run against the database and exit instead of starting the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "recompute-ratings":
+			if err := runRecomputeRatings(); err != nil {
+				panic(err)
+			}
+			fmt.Println("Recomputed ratings")
+		default:
+			fmt.Println("Unknown command", os.Args[1])
+			os.Exit(1)
+		}
+		return
+	}
+
+	resumeWebhookDeliveries()
+	go expireChallengesPeriodically()
 	router := gin.Default()
 	router.Use(CORSMiddleware())
 
-	router.POST("/register", registerPlayer)
-	router.POST("/login", login)
-	router.POST("/logout", ensureAuthenticated(), logout)
+	router.POST("/register", idempotencyMiddleware(), registerPlayer)
+	router.POST("/login", idempotencyMiddleware(), login)
+	router.POST("/logout", ensureAuthenticated(), idempotencyMiddleware(), logout)
 
 	playersGroup := router.Group("/players")
 	{
-		playersGroup.Use(ensureAuthenticated())
+		playersGroup.Use(ensureAuthenticated(), idempotencyMiddleware())
 
 		playersGroup.GET("", getPlayers)
 		playersGroup.GET("/:id", getPlayerWithID)
 
+		playersGroup.GET("/:id/stats", getPlayerStats)
+		playersGroup.GET("/:id/h2h/:otherId", getHeadToHead)
+		playersGroup.GET("/:id/ratings", getRatingHistory)
 		playersGroup.GET("/:id/comps", getPlayerComps)
 		playersGroup.GET("/:id/invite", getCompInvites)
 		playersGroup.PUT("/:id/invite/:compid", updateCompInvite)
@@ -51,22 +74,50 @@ func main() {
 
 	matchesGroup := router.Group("/matches")
 	{
-		matchesGroup.Use(ensureAuthenticated())
+		matchesGroup.Use(ensureAuthenticated(), idempotencyMiddleware())
 
 		matchesGroup.GET("/:id", getMatchFromID)
 		matchesGroup.DELETE("/:id", deleteMatchFromID)
 
 		matchesGroup.POST("/:id/score", scoreMatch)
+		matchesGroup.POST("/:id/points", scoreMatchPoints)
+		matchesGroup.POST("/:id/end", endMatch)
+		matchesGroup.POST("/:id/suspend", suspendMatch)
+		matchesGroup.POST("/:id/resume", resumeMatch)
 		matchesGroup.GET("/:id/stats", getMatchStats)
 
 		matchesGroup.GET("/:id/latest", getMatchLatestPoint)
+		matchesGroup.GET("/:id/live", streamMatch)
 		matchesGroup.DELETE("/:id/latest", deleteLatestPoint)
 
+		matchesGroup.GET("/:id/history", getPointHistory)
+		matchesGroup.POST("/:id/undo", undoPoints)
+		matchesGroup.POST("/:id/redo", redoPoints)
+
+	}
+
+	router.GET("/ratings", ensureAuthenticated(), getRatingLeaderboard)
+
+	statsGroup := router.Group("/stats")
+	{
+		statsGroup.Use(ensureAuthenticated(), idempotencyMiddleware())
+
+		statsGroup.GET("/matches", getMatchRangeStats)
+	}
+
+	seasonsGroup := router.Group("/seasons")
+	{
+		seasonsGroup.Use(ensureAuthenticated(), idempotencyMiddleware())
+
+		seasonsGroup.POST("", createSeason)
+		seasonsGroup.GET("", getSeasons)
+		seasonsGroup.GET("/:id", getSeasonWithID)
+		seasonsGroup.POST("/:id/rollover", rolloverSeason)
 	}
 
 	compsGroup := router.Group("/comps")
 	{
-		compsGroup.Use(ensureAuthenticated())
+		compsGroup.Use(ensureAuthenticated(), idempotencyMiddleware())
 
 		compsGroup.POST("", createComp)
 		compsGroup.GET("", getPublicComps)
@@ -79,10 +130,31 @@ func main() {
 
 			compIdGroup.GET("/matches", getCompMatches)
 			compIdGroup.POST("/matches", newMatchInComp)
+			compIdGroup.POST("/fixtures/round-robin", createRoundRobin)
+			compIdGroup.POST("/fixtures/swiss", createSwissRound)
+
+			compIdGroup.POST("/ladder", createLadder)
+			compIdGroup.GET("/ladder", getLadder)
+			compIdGroup.GET("/challenges", getCompChallenges)
+			compIdGroup.POST("/challenges", createChallenge)
+			compIdGroup.POST("/challenges/:challengeID/accept", acceptChallenge)
+			compIdGroup.POST("/challenges/:challengeID/decline", declineChallenge)
+			compIdGroup.POST("/draw/knockout", createKnockout)
+			compIdGroup.POST("/draw/double-elimination", createDoubleElimination)
+			compIdGroup.GET("/bracket", getCompBracket)
 
 			compIdGroup.POST("/invite", invitePlayersToComp)
 
 			compIdGroup.GET("/table", getCompTable)
+			compIdGroup.GET("/table/rules", getCompTableRules)
+			compIdGroup.PUT("/table/rules", updateCompTableRules)
+			compIdGroup.GET("/ratings", getCompRatings)
+			compIdGroup.GET("/live", streamComp)
+
+			compIdGroup.POST("/webhooks", createWebhook)
+			compIdGroup.GET("/webhooks", getWebhooks)
+			compIdGroup.DELETE("/webhooks/:webhookID", deleteWebhook)
+			compIdGroup.GET("/webhooks/:webhookID/deliveries", getWebhookDeliveries)
 		}
 
 	}
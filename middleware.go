@@ -15,7 +15,13 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -35,6 +41,7 @@ func ensureAuthenticated() gin.HandlerFunc {
 			handleNotAuthenticated(c)
 			return
 		}
+		c.Set(authPlayerKey, pid)
 	}
 }
 
@@ -49,7 +56,7 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Token, Idempotency-Key")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
 
 		if c.Request.Method == "OPTIONS" {
@@ -60,3 +67,132 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// Context key ensureAuthenticated stores the caller's player id under
+const authPlayerKey = "playerID"
+
+// How long a stored response is replayed for retries with the same Idempotency-Key
+const idempotencyWindow = 24 * time.Hour
+
+// Records the response body as it is written so it can be stored
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Honours the Idempotency-Key header on POST requests, must run after ensureAuthenticated where a route has it
+//
+// The first response for a key is stored and replayed for any retry within the idempotency window,
+// keys are scoped to the authenticated player, or shared by unauthenticated requests, and can only be
+// used for one endpoint and request body
+// Server errors and panics aren't stored so the request can be retried
+func idempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		// Unauthenticated requests are stored under player 0, which no player has
+		playerID := c.GetInt(authPlayerKey)
+		path := c.Request.URL.Path
+
+		// Hash the body so a retry can be told apart from a different request, then put it back for the handler
+		requestBody, err := io.ReadAll(c.Request.Body)
+		if handleError(err, c) {
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+		hash := sha256.Sum256(requestBody)
+		requestHash := hex.EncodeToString(hash[:])
+
+		// Keys outside the window are free to be used again
+		sqlStatement := `DELETE FROM idempotency_key WHERE player_id=$1 AND key=$2 AND created_at < $3`
+		_, err = db.Exec(sqlStatement, playerID, key, time.Now().Add(-idempotencyWindow))
+		if handleError(err, c) {
+			return
+		}
+
+		// Claim the key, if it is already taken replay the stored response
+		sqlStatement = `INSERT INTO idempotency_key (player_id, key, method, path, request_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, current_timestamp)
+		ON CONFLICT (player_id, key) DO NOTHING`
+		result, err := db.Exec(sqlStatement, playerID, key, c.Request.Method, path, requestHash)
+		if handleError(err, c) {
+			return
+		}
+
+		if claimed, err := result.RowsAffected(); err == nil && claimed == 0 {
+			replayIdempotentResponse(c, playerID, key, path, requestHash)
+			return
+		}
+
+		// Release the claim if the handler panics, Recovery turns the panic into a 500 further up the chain
+		stored := false
+		defer func() {
+			if stored {
+				return
+			}
+			_, err := db.Exec(`DELETE FROM idempotency_key WHERE player_id=$1 AND key=$2`, playerID, key)
+			if err != nil {
+				println(err.Error())
+			}
+		}()
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		if writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+
+		sqlStatement = `UPDATE idempotency_key SET status=$3, content_type=$4, body=$5 WHERE player_id=$1 AND key=$2`
+		_, err = db.Exec(sqlStatement, playerID, key, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes())
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		stored = true
+	}
+}
+
+// Helper function
+//
+// Responds with the stored response for an Idempotency-Key
+// Responds with 409 if the first request is still running, or 422 if the key was used for another endpoint or body
+func replayIdempotentResponse(c *gin.Context, playerID int, key, path, requestHash string) {
+	var storedPath string
+	var storedHash sql.NullString
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+
+	sqlStatement := `SELECT path, request_hash, status, content_type, body FROM idempotency_key WHERE player_id=$1 AND key=$2`
+	err := db.QueryRow(sqlStatement, playerID, key).Scan(&storedPath, &storedHash, &status, &contentType, &body)
+	if handleError(err, c) {
+		return
+	}
+
+	if storedPath != path || storedHash.String != requestHash {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, ErrorResposne{Message: "Idempotency-Key was used for a different request"})
+		return
+	} else if !status.Valid {
+		c.AbortWithStatusJSON(http.StatusConflict, ErrorResposne{Message: "A request with this Idempotency-Key is still in progress"})
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(int(status.Int64), contentType.String, body)
+	c.Abort()
+}
@@ -0,0 +1,268 @@
+-- Schema changes for set and game scoring, match formats and history, webhooks, ratings,
+-- draws, ladders, tables and seasons.
+--
+-- Run once against an existing database before deploying, e.g. psql -f migrations/001_scoring_and_competitions.sql
+-- Every statement can be run again safely, and existing rows are left as they were: code reading
+-- the new nullable columns treats NULL as a match, point or player from before the change.
+
+BEGIN;
+
+-- Matches
+
+ALTER TABLE match
+	ADD COLUMN IF NOT EXISTS version int NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS status text,
+	ADD COLUMN IF NOT EXISTS round int,
+	ADD COLUMN IF NOT EXISTS format_name text,
+	ADD COLUMN IF NOT EXISTS best_of int,
+	ADD COLUMN IF NOT EXISTS set_games int,
+	ADD COLUMN IF NOT EXISTS tiebreak_at int,
+	ADD COLUMN IF NOT EXISTS tiebreak_points int,
+	ADD COLUMN IF NOT EXISTS sudden_death_tiebreak boolean,
+	ADD COLUMN IF NOT EXISTS no_ad boolean,
+	ADD COLUMN IF NOT EXISTS match_tiebreak boolean,
+	ADD COLUMN IF NOT EXISTS match_tiebreak_points int;
+
+ALTER TABLE match_participant
+	ADD COLUMN IF NOT EXISTS team int,
+	ADD COLUMN IF NOT EXISTS serve_order int;
+
+ALTER TABLE match_result
+	ADD COLUMN IF NOT EXISTS reason text;
+
+ALTER TABLE comp
+	ADD COLUMN IF NOT EXISTS comp_type text;
+
+CREATE TABLE IF NOT EXISTS set (
+	id serial PRIMARY KEY,
+	match_id int NOT NULL REFERENCES match (id),
+	number int NOT NULL
+);
+
+ALTER TABLE set
+	ADD COLUMN IF NOT EXISTS winner_id int REFERENCES player (id);
+
+CREATE TABLE IF NOT EXISTS game (
+	id serial PRIMARY KEY,
+	set_id int NOT NULL REFERENCES set (id),
+	number int NOT NULL,
+	server_id int REFERENCES player (id),
+	receiver_id int REFERENCES player (id)
+);
+
+ALTER TABLE game
+	ADD COLUMN IF NOT EXISTS is_tiebreak boolean NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS winner_id int REFERENCES player (id);
+
+ALTER TABLE point
+	ADD COLUMN IF NOT EXISTS game_id int REFERENCES game (id),
+	ADD COLUMN IF NOT EXISTS serve_number int,
+	ADD COLUMN IF NOT EXISTS serve_direction text,
+	ADD COLUMN IF NOT EXISTS ending text,
+	ADD COLUMN IF NOT EXISTS shot_type text,
+	ADD COLUMN IF NOT EXISTS rally_length int,
+	ADD COLUMN IF NOT EXISTS striker_id int REFERENCES player (id);
+
+-- Every point scored, undone and redone in a match
+CREATE TABLE IF NOT EXISTS point_event (
+	id serial PRIMARY KEY,
+	match_id int NOT NULL REFERENCES match (id),
+	action text NOT NULL,
+	point_number int,
+	winner_id int REFERENCES player (id),
+	faults int NOT NULL DEFAULT 0,
+	lets int NOT NULL DEFAULT 0,
+	ace boolean,
+	unforced_error boolean,
+	scored_at timestamptz,
+	serve_number int,
+	serve_direction text,
+	ending text,
+	shot_type text,
+	rally_length int,
+	striker_id int REFERENCES player (id),
+	created_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS point_event_match_id ON point_event (match_id, id);
+
+-- Stored responses for POST requests sent with an Idempotency-Key
+CREATE TABLE IF NOT EXISTS idempotency_key (
+	player_id int NOT NULL,
+	key text NOT NULL,
+	method text NOT NULL,
+	path text NOT NULL,
+	request_hash text,
+	status int,
+	content_type text,
+	body bytea,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp,
+	PRIMARY KEY (player_id, key)
+);
+
+-- Webhooks
+
+CREATE TABLE IF NOT EXISTS webhook (
+	id serial PRIMARY KEY,
+	comp_id int NOT NULL REFERENCES comp (id),
+	url text NOT NULL,
+	secret text NOT NULL,
+	events text[] NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+	id serial PRIMARY KEY,
+	webhook_id int NOT NULL REFERENCES webhook (id),
+	event text NOT NULL,
+	payload bytea NOT NULL,
+	status text NOT NULL,
+	attempts int NOT NULL DEFAULT 0,
+	response_status int,
+	error text,
+	next_attempt timestamptz,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp,
+	delivered_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS webhook_delivery_pending ON webhook_delivery (status, next_attempt);
+
+-- Ratings, kept globally under comp 0 as well as for each comp
+
+CREATE TABLE IF NOT EXISTS player_rating (
+	player_id int NOT NULL REFERENCES player (id),
+	comp_id int NOT NULL,
+	rating float8 NOT NULL,
+	deviation float8 NOT NULL,
+	volatility float8 NOT NULL,
+	matches int NOT NULL DEFAULT 0,
+	updated_at timestamptz NOT NULL DEFAULT current_timestamp,
+	PRIMARY KEY (player_id, comp_id)
+);
+
+CREATE TABLE IF NOT EXISTS rating_history (
+	id serial PRIMARY KEY,
+	player_id int NOT NULL REFERENCES player (id),
+	comp_id int NOT NULL,
+	match_id int NOT NULL REFERENCES match (id),
+	rating_before float8 NOT NULL,
+	deviation_before float8 NOT NULL,
+	volatility_before float8 NOT NULL,
+	rating float8 NOT NULL,
+	deviation float8 NOT NULL,
+	volatility float8 NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS rating_history_match_id ON rating_history (match_id);
+CREATE INDEX IF NOT EXISTS rating_history_player ON rating_history (player_id, comp_id, id);
+
+-- Knockout, consolation and double elimination draws
+
+CREATE TABLE IF NOT EXISTS bracket_slot (
+	id serial PRIMARY KEY,
+	comp_id int NOT NULL REFERENCES comp (id),
+	bracket text NOT NULL,
+	round int NOT NULL,
+	position int NOT NULL,
+	player1_id int REFERENCES player (id),
+	player2_id int REFERENCES player (id),
+	player1_bye boolean NOT NULL DEFAULT false,
+	player2_bye boolean NOT NULL DEFAULT false,
+	seed1 int,
+	seed2 int,
+	match_id int REFERENCES match (id),
+	winner_id int REFERENCES player (id),
+	decided boolean NOT NULL DEFAULT false,
+	date timestamptz NOT NULL,
+	winner_to_slot int REFERENCES bracket_slot (id),
+	winner_to_side int,
+	loser_to_slot int REFERENCES bracket_slot (id),
+	loser_to_side int,
+	reset boolean NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS bracket_slot_match_id ON bracket_slot (match_id);
+
+-- Players given a bye in a Swiss round
+CREATE TABLE IF NOT EXISTS swiss_bye (
+	comp_id int NOT NULL REFERENCES comp (id),
+	round int NOT NULL,
+	player_id int NOT NULL REFERENCES player (id),
+	PRIMARY KEY (comp_id, round)
+);
+
+-- Challenge ladders
+
+CREATE TABLE IF NOT EXISTS ladder (
+	comp_id int PRIMARY KEY REFERENCES comp (id),
+	challenge_range int NOT NULL,
+	challenge_days int NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ladder_position (
+	comp_id int NOT NULL REFERENCES comp (id),
+	player_id int NOT NULL REFERENCES player (id),
+	position int NOT NULL,
+	PRIMARY KEY (comp_id, player_id)
+);
+
+CREATE TABLE IF NOT EXISTS challenge (
+	id serial PRIMARY KEY,
+	comp_id int NOT NULL REFERENCES comp (id),
+	challenger_id int NOT NULL REFERENCES player (id),
+	defender_id int NOT NULL REFERENCES player (id),
+	challenger_position int NOT NULL,
+	defender_position int NOT NULL,
+	status text NOT NULL,
+	match_id int REFERENCES match (id),
+	winner_id int REFERENCES player (id),
+	created_at timestamptz NOT NULL DEFAULT current_timestamp,
+	deadline timestamptz NOT NULL,
+	resolved_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS challenge_open ON challenge (status, deadline);
+
+-- League tables, one row of rules per comp
+CREATE TABLE IF NOT EXISTS table_rules (
+	comp_id int PRIMARY KEY REFERENCES comp (id),
+	win_points int NOT NULL,
+	loss_points int NOT NULL,
+	walkover_win_points int NOT NULL,
+	walkover_loss_points int NOT NULL,
+	tiebreakers text[] NOT NULL
+);
+
+-- Seasons
+
+CREATE TABLE IF NOT EXISTS season (
+	id serial PRIMARY KEY,
+	name text NOT NULL,
+	start_date timestamptz NOT NULL,
+	end_date timestamptz,
+	promote int NOT NULL,
+	relegate int NOT NULL,
+	previous_season_id int REFERENCES season (id),
+	closed boolean NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS season_division (
+	season_id int NOT NULL REFERENCES season (id),
+	comp_id int NOT NULL REFERENCES comp (id),
+	level int NOT NULL,
+	PRIMARY KEY (season_id, comp_id)
+);
+
+-- Final tables of a closed season, row holds the competitor as JSON as it was shown
+CREATE TABLE IF NOT EXISTS season_standing (
+	season_id int NOT NULL REFERENCES season (id),
+	comp_id int NOT NULL REFERENCES comp (id),
+	position int NOT NULL,
+	player_id int NOT NULL REFERENCES player (id),
+	movement text NOT NULL,
+	row bytea NOT NULL,
+	PRIMARY KEY (season_id, comp_id, player_id)
+);
+
+COMMIT;
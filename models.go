@@ -14,7 +14,13 @@
 
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"tennis-api/rating"
+	"tennis-api/scoring"
+)
 
 type PlayerRegister struct {
 	FirstName string `form:"first_name" binding:"required"`
@@ -44,6 +50,7 @@ type Competition struct {
 	CreatorID   *int    `json:"creatorID"`
 	PlayerCount int     `json:"playerCount"`
 	PlayerPos   *int    `json:"pos"`
+	Type        *string `json:"type"`
 }
 
 type CompetitionResponse struct {
@@ -74,35 +81,204 @@ type Match struct {
 	Competition *Competition `json:"competition"`
 	Player1     *Player      `json:"player1"`
 	Player2     *Player      `json:"player2"`
+	Team1       []Player     `json:"team1"`
+	Team2       []Player     `json:"team2"`
+	Doubles     bool         `json:"doubles"`
 	StartDate   *time.Time   `json:"startDate"`
+	Round       *int         `json:"round"`
 	EndDate     *time.Time   `json:"endDate"`
 	WinnerID    *int         `json:"winnerID"`
+	EndReason   *string      `json:"endReason"`
+	Status      string       `json:"status"`
+	Version     int          `json:"version"`
 	Score       *MatchScore  `json:"score"`
+	Sets        []SetScore   `json:"sets"`
+	SetsWon     *MatchScore  `json:"setsWon"`
+	Format      *MatchFormat `json:"format"`
+}
+
+type MatchFormat struct {
+	Name string `json:"name"`
+	scoring.Format
 }
 
+type MatchFormatRequest struct {
+	Format              string `form:"format"`
+	BestOf              *int   `form:"bestOf"`
+	SetGames            *int   `form:"setGames"`
+	TiebreakAt          *int   `form:"tiebreakAt"`
+	TiebreakPoints      *int   `form:"tiebreakPoints"`
+	SuddenDeathTiebreak *bool  `form:"suddenDeathTiebreak"`
+	NoAd                *bool  `form:"noAd"`
+	MatchTiebreak       *bool  `form:"matchTiebreak"`
+	MatchTiebreakPoints *int   `form:"matchTiebreakPoints"`
+}
+
+// Reasons a match ended, stored with the match result
+const (
+	EndCompleted = "completed"
+	EndRetired   = "retired"
+	EndWalkover  = "walkover"
+	EndDefault   = "default"
+	EndAbandoned = "abandoned"
+)
+
+// Match lifecycle statuses
+const (
+	StatusScheduled  = "scheduled"
+	StatusInProgress = "in_progress"
+	StatusSuspended  = "suspended"
+	StatusCompleted  = "completed"
+)
+
+// Selects the status of a match joined with its result,
+// matches created before statuses were stored are completed if they have a result and in progress otherwise
+const matchStatusColumn = `COALESCE(match.status, CASE WHEN match_result.match_id IS NULL THEN 'in_progress' ELSE 'completed' END)`
+
 type MatchScore struct {
 	Player1 int `json:"player1"`
 	Player2 int `json:"player2"`
 }
 
 type ScoreResponse struct {
-	Point              *int `json:"pointNum"`
-	NewServer          *int `json:"newServer"`
-	LastPointWinnerPts int  `json:"lastWinnersPoints"`
-	OtherPlayerPoints  int  `json:"otherPlayersPoints"`
+	Point              *int        `json:"pointNum"`
+	NewServer          *int        `json:"newServer"`
+	NewReceiver        *int        `json:"newReceiver"`
+	LastPointWinnerPts int         `json:"lastWinnersPoints"`
+	OtherPlayerPoints  int         `json:"otherPlayersPoints"`
+	Game               *GameScore  `json:"game"`
+	Sets               []SetScore  `json:"sets"`
+	SetsWon            *MatchScore `json:"setsWon"`
+	Tiebreak           bool        `json:"tiebreak"`
+	Version            int         `json:"version"`
+}
+
+type ScoreConflictResponse struct {
+	Message string        `json:"error"`
+	Current ScoreResponse `json:"current"`
+}
+
+type GameScore struct {
+	Player1 string `json:"player1"`
+	Player2 string `json:"player2"`
+}
+
+type SetScore struct {
+	Number   int         `json:"number"`
+	Player1  int         `json:"player1"`
+	Player2  int         `json:"player2"`
+	WinnerID *int        `json:"winnerID"`
+	Tiebreak *MatchScore `json:"tiebreak"`
 }
 
 type PlayerMatchStats struct {
-	Player       *Player `json:"player"`
-	Faults       int     `json:"faults"`
-	DoubleFaults int     `json:"doubleFaults"`
-	Lets         int     `json:"lets"`
-	Aces         int     `json:"aces"`
-	Errors       int     `json:"errors"`
+	Player       *Player    `json:"player"`
+	Faults       int        `json:"faults"`
+	DoubleFaults int        `json:"doubleFaults"`
+	Lets         int        `json:"lets"`
+	Aces         int        `json:"aces"`
+	Errors       int        `json:"errors"`
+	Serve        ServeStats `json:"serve"`
+	Shots        ShotStats  `json:"shots"`
+	Sheet        StatSheet  `json:"statSheet"`
+}
+
+type TeamMatchStats struct {
+	Players      []Player   `json:"players"`
+	PointsWon    int        `json:"pointsWon"`
+	Faults       int        `json:"faults"`
+	DoubleFaults int        `json:"doubleFaults"`
+	Lets         int        `json:"lets"`
+	Aces         int        `json:"aces"`
+	Errors       int        `json:"errors"`
+	Serve        ServeStats `json:"serve"`
+	Shots        ShotStats  `json:"shots"`
+	Sheet        StatSheet  `json:"statSheet"`
+}
+
+type ServeStats struct {
+	ServePoints          int            `json:"servePoints"`
+	ServePointsWon       int            `json:"servePointsWon"`
+	FirstServesIn        int            `json:"firstServesIn"`
+	FirstServePointsWon  int            `json:"firstServePointsWon"`
+	SecondServes         int            `json:"secondServes"`
+	SecondServesIn       int            `json:"secondServesIn"`
+	SecondServePointsWon int            `json:"secondServePointsWon"`
+	Directions           map[string]int `json:"directions"`
+}
+
+type ShotStats struct {
+	Winners        int            `json:"winners"`
+	ForcedErrors   int            `json:"forcedErrors"`
+	UnforcedErrors int            `json:"unforcedErrors"`
+	WinnersByShot  map[string]int `json:"winnersByShot"`
+	ErrorsByShot   map[string]int `json:"errorsByShot"`
+}
+
+type RallyStats struct {
+	Points  int     `json:"points"`
+	Average float64 `json:"average"`
+	Longest int     `json:"longest"`
+}
+
+// Actions recorded in a match's point history
+const (
+	ActionScore = "score"
+	ActionUndo  = "undo"
+	ActionRedo  = "redo"
+)
+
+type PointEvent struct {
+	ID            int        `json:"id"`
+	Action        string     `json:"action"`
+	PointNum      *int       `json:"pointNum"`
+	WinnerID      *int       `json:"winnerID"`
+	Faults        int        `json:"faults"`
+	Lets          int        `json:"lets"`
+	Ace           *bool      `json:"ace"`
+	UnforcedError *bool      `json:"unforcedError"`
+	ScoredAt      *time.Time `json:"scoredAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	PointDetail
+}
+
+// A point as scored by the client, used when scoring a single point or uploading a batch
+type PointRequest struct {
+	PointNum      int        `form:"pointNum" json:"pointNum" binding:"required"`
+	Faults        int        `form:"faults" json:"faults"`
+	Lets          int        `form:"lets" json:"lets"`
+	Ace           *bool      `form:"ace" json:"ace"`
+	UnforcedError *bool      `form:"unforcedError" json:"unforcedError"`
+	WinnerID      int        `form:"winnerID" json:"winnerID" binding:"required"`
+	ScoredAt      *time.Time `form:"scoredAt" json:"scoredAt"`
+	PointDetail
+}
+
+// Batch point statuses, points before a conflict are not applied and points after it are skipped
+const (
+	BatchAccepted   = "accepted"
+	BatchNotApplied = "not_applied"
+	BatchConflict   = "conflict"
+	BatchSkipped    = "skipped"
+)
+
+type BatchPointResult struct {
+	PointNum int    `json:"pointNum"`
+	Status   string `json:"status"`
+	Message  string `json:"error,omitempty"`
+}
+
+type BatchPointResponse struct {
+	Applied bool               `json:"applied"`
+	Points  []BatchPointResult `json:"points"`
+	Current ScoreResponse      `json:"current"`
 }
 
 type Point struct {
 	Number     int        `json:"number"`
+	Set        int        `json:"set"`
+	Game       int        `json:"game"`
+	Tiebreak   bool       `json:"tiebreak"`
 	WinnerID   int        `json:"winnerID"`
 	ServerID   int        `json:"serverID"`
 	ReceiverID int        `json:"receiverID"`
@@ -115,4 +291,343 @@ type PointStats struct {
 	Lets        int  `json:"lets"`
 	Ace         bool `json:"ace"`
 	Error       bool `json:"error"`
+	PointDetail
+}
+
+// How a point ended
+const (
+	EndingWinner        = "winner"
+	EndingForcedError   = "forced_error"
+	EndingUnforcedError = "unforced_error"
+	EndingDoubleFault   = "double_fault"
+	EndingAce           = "ace"
+)
+
+// Where a serve was aimed
+const (
+	ServeWide = "wide"
+	ServeBody = "body"
+	ServeT    = "t"
+)
+
+// The shot that ended a point
+const (
+	ShotServe    = "serve"
+	ShotForehand = "forehand"
+	ShotBackhand = "backhand"
+	ShotVolley   = "volley"
+	ShotOverhead = "overhead"
+)
+
+// Optional detail recorded for a point
+// serveNumber is the serve that went in, strikerID is the player who hit the shot that ended the point
+type PointDetail struct {
+	ServeNumber    *int    `form:"serveNumber" json:"serveNumber"`
+	ServeDirection *string `form:"serveDirection" json:"serveDirection"`
+	Ending         *string `form:"ending" json:"ending"`
+	ShotType       *string `form:"shotType" json:"shotType"`
+	RallyLength    *int    `form:"rallyLength" json:"rallyLength"`
+	StrikerID      *int    `form:"strikerID" json:"strikerID"`
+}
+
+// Live event types
+const (
+	LiveSnapshot  = "snapshot"
+	LivePoint     = "point"
+	LiveUndo      = "undo"
+	LiveRedo      = "redo"
+	LiveStatus    = "status"
+	LiveEnd       = "end"
+	LiveHeartbeat = "heartbeat"
+)
+
+type LiveEvent struct {
+	Event string        `json:"event"`
+	Score ScoreResponse `json:"score"`
+	Match Match         `json:"match"`
+}
+
+// Webhook events
+const (
+	WebhookMatchCreated   = "match.created"
+	WebhookPointScored    = "point.scored"
+	WebhookMatchCompleted = "match.completed"
+	WebhookPlayerJoined   = "player.joined"
+	WebhookInviteAccepted = "invite.accepted"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID        int       `json:"id"`
+	CompID    int       `json:"compID"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	CompID    int         `json:"compID"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	WebhookID      int             `json:"webhookID"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"responseStatus"`
+	Error          *string         `json:"error"`
+	NextAttempt    *time.Time      `json:"nextAttempt"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt"`
+}
+
+// The serve and return stats for a player or team over one or more matches, matches only counts matches with points played
+type StatSheet struct {
+	Matches                 int     `json:"matches"`
+	PointsPlayed            int     `json:"pointsPlayed"`
+	PointsWon               int     `json:"pointsWon"`
+	PointsWonPct            float64 `json:"pointsWonPct"`
+	ServePoints             int     `json:"servePoints"`
+	ServePointsWon          int     `json:"servePointsWon"`
+	ServePointsWonPct       float64 `json:"servePointsWonPct"`
+	FirstServesIn           int     `json:"firstServesIn"`
+	FirstServePct           float64 `json:"firstServePct"`
+	FirstServePointsWon     int     `json:"firstServePointsWon"`
+	FirstServeWonPct        float64 `json:"firstServeWonPct"`
+	SecondServePoints       int     `json:"secondServePoints"`
+	SecondServePointsWon    int     `json:"secondServePointsWon"`
+	SecondServeWonPct       float64 `json:"secondServeWonPct"`
+	Aces                    int     `json:"aces"`
+	DoubleFaults            int     `json:"doubleFaults"`
+	BreakPointsFaced        int     `json:"breakPointsFaced"`
+	BreakPointsSaved        int     `json:"breakPointsSaved"`
+	BreakPointsSavedPct     float64 `json:"breakPointsSavedPct"`
+	BreakPointChances       int     `json:"breakPointChances"`
+	BreakPointsConverted    int     `json:"breakPointsConverted"`
+	BreakPointsConvertedPct float64 `json:"breakPointsConvertedPct"`
+	ServiceGames            int     `json:"serviceGames"`
+	ServiceGamesHeld        int     `json:"serviceGamesHeld"`
+	HoldPct                 float64 `json:"holdPct"`
+	ReturnGames             int     `json:"returnGames"`
+	ReturnGamesWon          int     `json:"returnGamesWon"`
+	BreakPct                float64 `json:"breakPct"`
+	ReturnPoints            int     `json:"returnPoints"`
+	ReturnPointsWon         int     `json:"returnPointsWon"`
+	ReturnPointsWonPct      float64 `json:"returnPointsWonPct"`
+}
+
+type PlayerStatSheet struct {
+	Player Player `json:"player"`
+	StatSheet
+}
+
+type Record struct {
+	Played int     `json:"played"`
+	Won    int     `json:"won"`
+	Lost   int     `json:"lost"`
+	WinPct float64 `json:"winPct"`
+}
+
+type CompRecord struct {
+	Comp Competition `json:"comp"`
+	Record
+}
+
+type FormResult struct {
+	MatchID   int        `json:"matchID"`
+	StartDate *time.Time `json:"startDate"`
+	Won       bool       `json:"won"`
+}
+
+type PlayerStats struct {
+	Player Player `json:"player"`
+	Record
+	Competitions         []CompRecord `json:"competitions"`
+	LongestWinStreak     int          `json:"longestWinStreak"`
+	CurrentWinStreak     int          `json:"currentWinStreak"`
+	Form                 []FormResult `json:"form"`
+	AcesPerMatch         float64      `json:"acesPerMatch"`
+	DoubleFaultsPerMatch float64      `json:"doubleFaultsPerMatch"`
+	Sheet                StatSheet    `json:"statSheet"`
+}
+
+type HeadToHead struct {
+	Player        Player     `json:"player"`
+	Opponent      Player     `json:"opponent"`
+	Record        Record     `json:"record"`
+	Sets          MatchScore `json:"sets"`
+	Games         MatchScore `json:"games"`
+	Points        MatchScore `json:"points"`
+	PlayerStats   StatSheet  `json:"playerStats"`
+	OpponentStats StatSheet  `json:"opponentStats"`
+	Matches       []Match    `json:"matches"`
+}
+
+type PlayerRating struct {
+	Rank   int    `json:"rank"`
+	Player Player `json:"player"`
+	rating.Rating
+	Matches     int  `json:"matches"`
+	Provisional bool `json:"provisional"`
+}
+
+type RatingChange struct {
+	MatchID   int           `json:"matchID"`
+	Before    rating.Rating `json:"before"`
+	After     rating.Rating `json:"after"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+type Competitor struct {
+	Player          Player  `json:"player"`
+	Points          int     `json:"points"`
+	Played          int     `json:"played"`
+	Wins            int     `json:"wins"`
+	Losses          int     `json:"losses"`
+	Byes            int     `json:"byes"`
+	SetsWon         int     `json:"setsWon"`
+	SetsLost        int     `json:"setsLost"`
+	SetDifference   int     `json:"setDifference"`
+	GamesWon        int     `json:"gamesWon"`
+	GamesLost       int     `json:"gamesLost"`
+	GameDifference  int     `json:"gameDifference"`
+	PointsWon       int     `json:"pointsWon"`
+	PointsLost      int     `json:"pointsLost"`
+	PointDifference int     `json:"pointDifference"`
+	Buchholz        float64 `json:"buchholz"`
+	SonnebornBerger float64 `json:"sonnebornBerger"`
+}
+
+type TableRules struct {
+	WinPoints          int      `json:"winPoints"`
+	LossPoints         int      `json:"lossPoints"`
+	WalkoverWinPoints  int      `json:"walkoverWinPoints"`
+	WalkoverLossPoints int      `json:"walkoverLossPoints"`
+	Tiebreakers        []string `json:"tiebreakers"`
+}
+
+type FixtureRound struct {
+	Round   int       `json:"round"`
+	Date    time.Time `json:"date"`
+	Matches []Match   `json:"matches"`
+	Byes    []int     `json:"byes"`
+}
+
+// Comp types
+const (
+	CompLeague            = "league"
+	CompKnockout          = "knockout"
+	CompDoubleElimination = "double_elimination"
+	CompSwiss             = "swiss"
+	CompLadder            = "ladder"
+)
+
+// Where a player goes at the end of a season
+const (
+	MovePromoted  = "promoted"
+	MoveRelegated = "relegated"
+	MoveStayed    = "stayed"
+	MoveLeft      = "left"
+)
+
+// Challenge statuses
+const (
+	ChallengePending   = "pending"
+	ChallengeAccepted  = "accepted"
+	ChallengeDeclined  = "declined"
+	ChallengeForfeited = "forfeited"
+	ChallengeCompleted = "completed"
+)
+
+// How players are seeded in a draw
+const (
+	SeedingRating = "rating"
+	SeedingManual = "manual"
+)
+
+type Bracket struct {
+	Name   string          `json:"name"`
+	Rounds [][]BracketSlot `json:"rounds"`
+}
+
+type BracketSlot struct {
+	ID       int         `json:"id"`
+	Round    int         `json:"round"`
+	Position int         `json:"position"`
+	Players  [2]*Player  `json:"players"`
+	Seeds    [2]*int     `json:"seeds"`
+	Byes     [2]bool     `json:"byes"`
+	Match    *Match      `json:"match"`
+	Winner   *Player     `json:"winner"`
+	Decided  bool        `json:"decided"`
+	Date     time.Time   `json:"date"`
+	WinnerTo *BracketRef `json:"winnerTo"`
+	LoserTo  *BracketRef `json:"loserTo"`
+	Reset    bool        `json:"reset"`
+}
+
+type BracketRef struct {
+	SlotID int `json:"slotID"`
+	Side   int `json:"side"`
+}
+
+type LadderRules struct {
+	Range int `json:"range"`
+	Days  int `json:"days"`
+}
+
+type LadderRung struct {
+	Position  int        `json:"position"`
+	Player    Player     `json:"player"`
+	Challenge *Challenge `json:"challenge"`
+}
+
+type Challenge struct {
+	ID                 int        `json:"id"`
+	CompID             int        `json:"compID"`
+	ChallengerID       int        `json:"challengerID"`
+	DefenderID         int        `json:"defenderID"`
+	ChallengerPosition int        `json:"challengerPosition"`
+	DefenderPosition   int        `json:"defenderPosition"`
+	Status             string     `json:"status"`
+	MatchID            *int       `json:"matchID"`
+	WinnerID           *int       `json:"winnerID"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	Deadline           time.Time  `json:"deadline"`
+	ResolvedAt         *time.Time `json:"resolvedAt"`
+}
+
+type Season struct {
+	ID               int        `json:"id"`
+	Name             string     `json:"name"`
+	StartDate        time.Time  `json:"startDate"`
+	EndDate          *time.Time `json:"endDate"`
+	Promote          int        `json:"promote"`
+	Relegate         int        `json:"relegate"`
+	PreviousSeasonID *int       `json:"previousSeasonID"`
+	Closed           bool       `json:"closed"`
+	Divisions        []Division `json:"divisions"`
+}
+
+type Division struct {
+	Level int           `json:"level"`
+	Comp  Competition   `json:"comp"`
+	Table []DivisionRow `json:"table,omitempty"`
+}
+
+type DivisionRow struct {
+	Position int    `json:"position"`
+	Movement string `json:"movement"`
+	Competitor
 }
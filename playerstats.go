@@ -0,0 +1,298 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Number of recent results returned as a player's form
+const formLength = 10
+
+// A finished match from a player's point of view
+type playerResult struct {
+	MatchID   int
+	Comp      Competition
+	StartDate *time.Time
+	Won       bool
+}
+
+// Helper function
+//
+// Returns the player's finished matches in the order they were played, abandoned matches are left out
+// A match is won if the winner was on the player's team, the same as the comp table
+// Filters are SQL conditions on the match with a %d for their argument's placeholder
+func getPlayerResults(playerID int, filters []string, args []interface{}) ([]playerResult, error) {
+	args = append([]interface{}{playerID}, args...)
+	conditions := ""
+	for i, filter := range filters {
+		conditions += " AND " + fmt.Sprintf(filter, i+2)
+	}
+
+	sqlStatement := `SELECT m.id, m.comp_id, comp.comp_name, comp.is_private, m.start_date,
+	COALESCE(w.team = mp.team, w.player_id = mp.player_id, FALSE) AS won
+	FROM match_participant mp
+	JOIN match m ON m.id = mp.match_id
+	JOIN match_result mr ON mr.match_id = m.id
+	LEFT JOIN comp ON comp.id = m.comp_id
+	LEFT JOIN match_participant w ON w.match_id = m.id AND w.player_id = mr.winner_id
+	WHERE mp.player_id = $1 AND (mr.reason IS NULL OR mr.reason != 'abandoned')` + conditions + `
+	ORDER BY m.start_date, m.id`
+
+	rows, err := db.Query(sqlStatement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []playerResult{}
+	for rows.Next() {
+		var result playerResult
+		err = rows.Scan(&result.MatchID, &result.Comp.Id, &result.Comp.Name, &result.Comp.IsPrivate, &result.StartDate, &result.Won)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// Helper function
+//
+// Reads the optional from, to and compID query params into match filters for getPlayerResults
+// Responds with 400 and returns false if a param is invalid
+func tryGetMatchFilters(c *gin.Context) ([]string, []interface{}, bool) {
+	var request struct {
+		CompID *int       `form:"compID"`
+		From   *time.Time `form:"from"`
+		To     *time.Time `form:"to"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return nil, nil, false
+	}
+
+	filters, args := []string{}, []interface{}{}
+	if request.CompID != nil {
+		filters, args = append(filters, "m.comp_id = $%d"), append(args, *request.CompID)
+	}
+	if request.From != nil {
+		filters, args = append(filters, "m.start_date >= $%d"), append(args, *request.From)
+	}
+	if request.To != nil {
+		filters, args = append(filters, "m.start_date <= $%d"), append(args, *request.To)
+	}
+	return filters, args, true
+}
+
+// Returns the player with the id
+func getPlayer(q queryer, playerID int) (Player, error) {
+	var player Player
+	sqlStatement := `SELECT id, first_name, last_name, is_admin FROM player where id=$1;`
+	err := q.QueryRow(sqlStatement, playerID).Scan(&player.Id, &player.FirstName, &player.LastName, &player.Admin)
+	return player, err
+}
+
+// Endpoint: /players/:id/stats
+//
+// Returns the player's record and stats across all their matches, optionally limited by compID and a from and to start date
+// Serve and return stats are summed over the matches the same way as getMatchStats, per match averages only count matches with points played
+func getPlayerStats(c *gin.Context) {
+	param := c.Param("id")
+	playerID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	filters, args, ok := tryGetMatchFilters(c)
+	if !ok {
+		return
+	}
+
+	player, err := getPlayer(db, playerID)
+	if handleError(err, c) {
+		return
+	}
+
+	results, err := getPlayerResults(playerID, filters, args)
+	if handleError(err, c) {
+		return
+	}
+
+	response := PlayerStats{Player: player, Competitions: []CompRecord{}, Form: []FormResult{}}
+
+	// Record overall and per comp, along with the longest winning streak
+	comps := map[int]int{}
+	streak := 0
+	matchIDs := []int{}
+	for _, result := range results {
+		matchIDs = append(matchIDs, result.MatchID)
+		response.Record.add(result.Won)
+
+		if result.Comp.Id != nil {
+			i, ok := comps[*result.Comp.Id]
+			if !ok {
+				i = len(response.Competitions)
+				comps[*result.Comp.Id] = i
+				response.Competitions = append(response.Competitions, CompRecord{Comp: result.Comp})
+			}
+			response.Competitions[i].Record.add(result.Won)
+		}
+
+		if result.Won {
+			streak++
+			if streak > response.LongestWinStreak {
+				response.LongestWinStreak = streak
+			}
+		} else {
+			streak = 0
+		}
+	}
+	response.CurrentWinStreak = streak
+
+	// Most recent results first
+	for i := len(results) - 1; i >= 0 && len(response.Form) < formLength; i-- {
+		response.Form = append(response.Form, FormResult{MatchID: results[i].MatchID, StartDate: results[i].StartDate, Won: results[i].Won})
+	}
+
+	sheets, err := sumStatSheets(matchIDs)
+	if handleError(err, c) {
+		return
+	}
+	for _, sheet := range sheets {
+		if sheet.Player.Id == playerID {
+			response.Sheet = sheet.StatSheet
+		}
+	}
+	response.Sheet.finish()
+
+	if response.Sheet.Matches > 0 {
+		response.AcesPerMatch = float64(response.Sheet.Aces) / float64(response.Sheet.Matches)
+		response.DoubleFaultsPerMatch = float64(response.Sheet.DoubleFaults) / float64(response.Sheet.Matches)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (r *Record) add(won bool) {
+	r.Played++
+	if won {
+		r.Won++
+	} else {
+		r.Lost++
+	}
+	r.WinPct = percent(r.Won, r.Played)
+}
+
+// Endpoint: /players/:id/h2h/:otherId
+//
+// Returns every match the two players have played on opposite sides, optionally limited to one comp with compID
+// The record and the set, game and point totals are from the first player's point of view and only count finished matches,
+// along with each player's serve and return stats over the matches
+func getHeadToHead(c *gin.Context) {
+	playerID, err := strconv.Atoi(c.Param("id"))
+	if handleError(err, c) {
+		return
+	}
+	otherID, err := strconv.Atoi(c.Param("otherId"))
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		CompID *int `form:"compID"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	var response HeadToHead
+	response.Player, err = getPlayer(db, playerID)
+	if handleError(err, c) {
+		return
+	}
+	response.Opponent, err = getPlayer(db, otherID)
+	if handleError(err, c) {
+		return
+	}
+
+	sqlStatement := `SELECT m.id FROM match m
+	JOIN match_participant a ON a.match_id = m.id AND a.player_id = $1
+	JOIN match_participant b ON b.match_id = m.id AND b.player_id = $2
+	WHERE COALESCE(a.team != b.team, TRUE) AND ($3::int IS NULL OR m.comp_id = $3)
+	ORDER BY m.start_date, m.id`
+	matchIDs, err := queryMatchIDs(sqlStatement, playerID, otherID, request.CompID)
+	if handleError(err, c) {
+		return
+	}
+
+	response.Matches = []Match{}
+	for _, matchID := range matchIDs {
+		match, err := getMatch(matchID)
+		if handleError(err, c) {
+			return
+		}
+		response.Matches = append(response.Matches, match)
+
+		if match.WinnerID == nil || match.Status != StatusCompleted {
+			continue
+		}
+
+		// Scores are stored from team 1's point of view, swap them if the player was on team 2
+		teams := matchTeams{match.Team1, match.Team2}
+		side := teams.side(playerID)
+		flip := func(score MatchScore) MatchScore {
+			if side == 1 {
+				return MatchScore{Player1: score.Player2, Player2: score.Player1}
+			}
+			return score
+		}
+
+		response.Record.add(teams.side(*match.WinnerID) == side)
+		response.Sets.add(flip(*match.SetsWon))
+		response.Points.add(flip(*match.Score))
+		for _, set := range match.Sets {
+			response.Games.add(flip(MatchScore{Player1: set.Player1, Player2: set.Player2}))
+		}
+	}
+
+	sheets, err := sumStatSheets(matchIDs)
+	if handleError(err, c) {
+		return
+	}
+	for _, sheet := range sheets {
+		switch sheet.Player.Id {
+		case playerID:
+			response.PlayerStats = sheet.StatSheet
+		case otherID:
+			response.OpponentStats = sheet.StatSheet
+		}
+	}
+	response.PlayerStats.finish()
+	response.OpponentStats.finish()
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *MatchScore) add(other MatchScore) {
+	s.Player1 += other.Player1
+	s.Player2 += other.Player2
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rating implements the Glicko-2 rating system, without a database.
+//
+// Each match is treated as its own rating period, so ratings move after every result.
+// See http://www.glicko.net/glicko/glicko2.pdf for the system itself.
+package rating
+
+import "math"
+
+const (
+	// Rating, deviation and volatility given to a player before their first match
+	DefaultRating     = 1500.0
+	DefaultDeviation  = 350.0
+	DefaultVolatility = 0.06
+
+	// Constrains how quickly volatility changes, Glickman suggests between 0.3 and 1.2
+	Tau = 0.5
+
+	// Converts between the Glicko scale and the Glicko-2 scale
+	scale = 173.7178
+
+	convergence = 0.000001
+)
+
+// Rating is a player's skill estimate, the deviation is how uncertain it is
+type Rating struct {
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+}
+
+// Outcome is a result against one opponent, score is 1 for a win, 0 for a loss and 0.5 for a draw
+type Outcome struct {
+	Opponent Rating
+	Score    float64
+}
+
+// Returns the rating of a new player
+func Default() Rating {
+	return Rating{Rating: DefaultRating, Deviation: DefaultDeviation, Volatility: DefaultVolatility}
+}
+
+// Returns a composite rating for a doubles team, the mean rating with the root mean square of the deviations
+func Team(ratings ...Rating) Rating {
+	if len(ratings) == 0 {
+		return Default()
+	}
+
+	var team Rating
+	for _, r := range ratings {
+		team.Rating += r.Rating
+		team.Deviation += r.Deviation * r.Deviation
+		team.Volatility += r.Volatility
+	}
+	n := float64(len(ratings))
+	team.Rating /= n
+	team.Deviation = math.Sqrt(team.Deviation / n)
+	team.Volatility /= n
+	return team
+}
+
+// Returns the rating after a rating period with the outcomes
+// With no outcomes only the deviation grows, to reflect the time without a result
+func Update(r Rating, outcomes []Outcome) Rating {
+	mu := (r.Rating - DefaultRating) / scale
+	phi := r.Deviation / scale
+	sigma := r.Volatility
+
+	if len(outcomes) == 0 {
+		return Rating{Rating: r.Rating, Deviation: math.Sqrt(phi*phi+sigma*sigma) * scale, Volatility: sigma}
+	}
+
+	// Estimated variance from the outcomes, and the improvement they suggest
+	var vInv, sum float64
+	for _, outcome := range outcomes {
+		muJ := (outcome.Opponent.Rating - DefaultRating) / scale
+		gJ := g(outcome.Opponent.Deviation / scale)
+		e := expected(mu, muJ, gJ)
+		vInv += gJ * gJ * e * (1 - e)
+		sum += gJ * (outcome.Score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	sigma = volatility(delta, phi, v, sigma)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+
+	return Rating{Rating: mu*scale + DefaultRating, Deviation: phi * scale, Volatility: sigma}
+}
+
+// Returns the probability that a player with rating r beats the opponent
+func Expected(r, opponent Rating) float64 {
+	return expected((r.Rating-DefaultRating)/scale, (opponent.Rating-DefaultRating)/scale, g(opponent.Deviation/scale))
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func expected(mu, muJ, gJ float64) float64 {
+	return 1 / (1 + math.Exp(-gJ*(mu-muJ)))
+}
+
+// Finds the new volatility with the Illinois algorithm, step 5 of the Glicko-2 paper
+func volatility(delta, phi, v, sigma float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(Tau*Tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*Tau) < 0 {
+			k++
+		}
+		B = a - k*Tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > convergence {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+
+	return math.Exp(A / 2)
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rating
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+// The worked example from the Glicko-2 paper, a 1500 player with deviation 200 playing three opponents
+func TestUpdatePaperExample(t *testing.T) {
+	player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	outcomes := []Outcome{
+		{Opponent: Rating{Rating: 1400, Deviation: 30}, Score: 1},
+		{Opponent: Rating{Rating: 1550, Deviation: 100}, Score: 0},
+		{Opponent: Rating{Rating: 1700, Deviation: 300}, Score: 0},
+	}
+
+	got := Update(player, outcomes)
+	if !near(got.Rating, 1464.06, 0.01) || !near(got.Deviation, 151.52, 0.01) || !near(got.Volatility, 0.05999, 0.00001) {
+		t.Errorf("got %+v, want 1464.06, 151.52, 0.05999", got)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	strong := Rating{Rating: 1800, Deviation: 60, Volatility: 0.06}
+	weak := Rating{Rating: 1300, Deviation: 60, Volatility: 0.06}
+
+	tests := []struct {
+		name      string
+		player    Rating
+		outcomes  []Outcome
+		direction float64
+		// A result tells us little about a settled player against a far weaker or stronger one,
+		// so their deviation can still grow from volatility
+		certain bool
+	}{
+		{"win raises rating", Default(), []Outcome{{Opponent: Default(), Score: 1}}, 1, true},
+		{"loss lowers rating", Default(), []Outcome{{Opponent: Default(), Score: 0}}, -1, true},
+		{"draw between equals changes nothing", Default(), []Outcome{{Opponent: Default(), Score: 0.5}}, 0, true},
+		{"expected win", strong, []Outcome{{Opponent: weak, Score: 1}}, 1, false},
+		{"upset", weak, []Outcome{{Opponent: strong, Score: 1}}, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Update(test.player, test.outcomes)
+			change := got.Rating - test.player.Rating
+			switch {
+			case test.direction > 0 && change <= 0, test.direction < 0 && change >= 0, test.direction == 0 && !near(change, 0, 1e-9):
+				t.Errorf("rating moved by %f", change)
+			}
+			if test.certain && got.Deviation >= test.player.Deviation {
+				t.Errorf("deviation %f didn't shrink from %f", got.Deviation, test.player.Deviation)
+			}
+		})
+	}
+
+	expectedWin := Update(strong, []Outcome{{Opponent: weak, Score: 1}}).Rating - strong.Rating
+	upset := Update(weak, []Outcome{{Opponent: strong, Score: 1}}).Rating - weak.Rating
+	if expectedWin >= upset {
+		t.Errorf("expected win gained %f, upset %f", expectedWin, upset)
+	}
+}
+
+func TestUpdateWithoutOutcomes(t *testing.T) {
+	player := Rating{Rating: 1600, Deviation: 50, Volatility: 0.06}
+	got := Update(player, nil)
+	if got.Rating != player.Rating || got.Volatility != player.Volatility || got.Deviation <= player.Deviation {
+		t.Errorf("got %+v from %+v, only the deviation should grow", got, player)
+	}
+}
+
+func TestTeam(t *testing.T) {
+	tests := []struct {
+		name    string
+		ratings []Rating
+		want    Rating
+	}{
+		{"no players", nil, Default()},
+		{"one player", []Rating{{Rating: 1700, Deviation: 80, Volatility: 0.05}}, Rating{Rating: 1700, Deviation: 80, Volatility: 0.05}},
+		{"two players", []Rating{{Rating: 1600, Deviation: 30, Volatility: 0.06}, {Rating: 1400, Deviation: 40, Volatility: 0.04}},
+			Rating{Rating: 1500, Deviation: math.Sqrt((30*30 + 40*40) / 2.0), Volatility: 0.05}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Team(test.ratings...)
+			if !near(got.Rating, test.want.Rating, 1e-9) || !near(got.Deviation, test.want.Deviation, 1e-9) ||
+				!near(got.Volatility, test.want.Volatility, 1e-9) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpected(t *testing.T) {
+	if e := Expected(Default(), Default()); !near(e, 0.5, 1e-9) {
+		t.Errorf("equal players expected %f, want 0.5", e)
+	}
+
+	strong := Rating{Rating: 1800, Deviation: 60}
+	weak := Rating{Rating: 1300, Deviation: 60}
+	if e, other := Expected(strong, weak), Expected(weak, strong); e <= 0.5 || !near(e+other, 1, 1e-9) {
+		t.Errorf("strong player expected %f, weak %f", e, other)
+	}
+}
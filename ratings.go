@@ -0,0 +1,404 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"tennis-api/rating"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Ratings with a deviation above this are provisional, the player hasn't played enough to be sure of them
+const provisionalDeviation = 110.0
+
+// Ratings are kept globally under comp 0, as well as separately for each comp
+const globalRatings = 0
+
+// Helper function
+//
+// Updates the global and comp ratings of everyone in the match from its result, recording the change in their rating history
+// Walkovers and abandoned matches are not rated
+func rateMatch(q queryer, matchID int) error {
+	return rateMatchIn(q, matchID, nil)
+}
+
+// Helper function
+//
+// Rates the match like rateMatch, but only in the given scopes, or every scope the match is in if nil
+func rateMatchIn(q queryer, matchID int, only map[int]bool) error {
+	var compID *int
+	var winnerID *int
+	var reason *string
+	sqlStatement := `SELECT match.comp_id, winner_id, reason FROM match
+	JOIN match_result ON match_result.match_id = match.id
+	WHERE match.id = $1`
+	err := q.QueryRow(sqlStatement, matchID).Scan(&compID, &winnerID, &reason)
+	if err != nil {
+		return err
+	}
+
+	if winnerID == nil || (reason != nil && (*reason == EndWalkover || *reason == EndAbandoned)) {
+		return nil
+	}
+
+	teams, err := getMatchTeams(q, matchID)
+	if err != nil {
+		return err
+	}
+	winner := teams.side(*winnerID)
+	if winner < 0 {
+		return nil
+	}
+
+	scopes := []int{globalRatings}
+	if compID != nil {
+		scopes = append(scopes, *compID)
+	}
+
+	for _, scope := range scopes {
+		if only != nil && !only[scope] {
+			continue
+		}
+
+		// Everyone is rated against the other team as a whole, using ratings from before the match
+		var before [2][]rating.Rating
+		for side, team := range teams {
+			for _, player := range team {
+				r, err := getRating(q, player.Id, scope)
+				if err != nil {
+					return err
+				}
+				before[side] = append(before[side], r)
+			}
+		}
+
+		for side, team := range teams {
+			score := 0.0
+			if side == winner {
+				score = 1
+			}
+			opponent := rating.Team(before[1-side]...)
+
+			for i, player := range team {
+				after := rating.Update(before[side][i], []rating.Outcome{{Opponent: opponent, Score: score}})
+				err = setRating(q, player.Id, scope, matchID, before[side][i], after)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the player's current rating, the default rating if they haven't played a rated match
+func getRating(q queryer, playerID, compID int) (rating.Rating, error) {
+	r := rating.Default()
+	sqlStatement := `SELECT rating, deviation, volatility FROM player_rating WHERE player_id = $1 AND comp_id = $2`
+	err := q.QueryRow(sqlStatement, playerID, compID).Scan(&r.Rating, &r.Deviation, &r.Volatility)
+	if err == sql.ErrNoRows {
+		return rating.Default(), nil
+	}
+	return r, err
+}
+
+// Stores the player's new rating and adds the change to their history
+func setRating(q queryer, playerID, compID, matchID int, before, after rating.Rating) error {
+	sqlStatement := `INSERT INTO player_rating (player_id, comp_id, rating, deviation, volatility, matches, updated_at)
+	VALUES ($1, $2, $3, $4, $5, 1, current_timestamp)
+	ON CONFLICT (player_id, comp_id) DO UPDATE SET rating = $3, deviation = $4, volatility = $5,
+		matches = player_rating.matches + 1, updated_at = current_timestamp`
+	_, err := q.Exec(sqlStatement, playerID, compID, after.Rating, after.Deviation, after.Volatility)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement = `INSERT INTO rating_history (player_id, comp_id, match_id, rating_before, deviation_before, volatility_before,
+		rating, deviation, volatility, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, current_timestamp)`
+	_, err = q.Exec(sqlStatement, playerID, compID, matchID, before.Rating, before.Deviation, before.Volatility,
+		after.Rating, after.Deviation, after.Volatility)
+	return err
+}
+
+// A change recorded in a player's rating history
+type ratingChange struct {
+	id       int
+	playerID int
+	compID   int
+	matchID  int
+	before   rating.Rating
+}
+
+// Helper function
+//
+// Takes back the rating changes from a match whose result is being removed
+// Later matches are rated again in the order they were rated if a player in them has had their rating changed as a result,
+// by playing in the match or against someone who has since, ratings from every other match are left as they are
+func unrateMatch(q queryer, matchID int) error {
+	// Every change in each of the match's scopes since the match was rated, from whole matches
+	sqlStatement := `SELECT l.id, l.player_id, l.comp_id, l.match_id, l.rating_before, l.deviation_before, l.volatility_before
+	FROM rating_history l
+	WHERE l.match_id IN (SELECT r.match_id FROM rating_history r WHERE r.comp_id = l.comp_id
+		AND r.id >= (SELECT MIN(h.id) FROM rating_history h WHERE h.match_id = $1 AND h.comp_id = l.comp_id))
+	ORDER BY l.id`
+	rows, err := q.Query(sqlStatement, matchID)
+	if err != nil {
+		return err
+	}
+
+	scopes := []int{}
+	matches := map[int][]int{}
+	changes := map[[2]int][]ratingChange{}
+	for rows.Next() {
+		var change ratingChange
+		err = rows.Scan(&change.id, &change.playerID, &change.compID, &change.matchID, &change.before.Rating,
+			&change.before.Deviation, &change.before.Volatility)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		if _, ok := matches[change.compID]; !ok {
+			scopes = append(scopes, change.compID)
+		}
+		key := [2]int{change.compID, change.matchID}
+		if len(changes[key]) == 0 {
+			matches[change.compID] = append(matches[change.compID], change.matchID)
+		}
+		changes[key] = append(changes[key], change)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, scope := range scopes {
+		// Follow the players whose ratings change through the scope's later matches
+		affected := map[int]bool{}
+		restore := map[int]rating.Rating{}
+		undone := map[int]int{}
+		undoneIDs := []int{}
+		rerate := []int{}
+		for _, ratedID := range matches[scope] {
+			hit := ratedID == matchID
+			for _, change := range changes[[2]int{scope, ratedID}] {
+				hit = hit || affected[change.playerID]
+			}
+			if !hit {
+				continue
+			}
+
+			for _, change := range changes[[2]int{scope, ratedID}] {
+				if !affected[change.playerID] {
+					affected[change.playerID] = true
+					restore[change.playerID] = change.before
+				}
+				undone[change.playerID]++
+				undoneIDs = append(undoneIDs, change.id)
+			}
+			if ratedID != matchID {
+				rerate = append(rerate, ratedID)
+			}
+		}
+
+		// Put the affected players back to their rating before the match and rate the later matches again
+		_, err = q.Exec(`DELETE FROM rating_history WHERE id = ANY($1)`, pq.Array(undoneIDs))
+		if err != nil {
+			return err
+		}
+
+		for playerID, before := range restore {
+			sqlStatement = `UPDATE player_rating SET rating = $3, deviation = $4, volatility = $5, matches = matches - $6,
+				updated_at = current_timestamp
+			WHERE player_id = $1 AND comp_id = $2`
+			_, err = q.Exec(sqlStatement, playerID, scope, before.Rating, before.Deviation, before.Volatility, undone[playerID])
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, ratedID := range rerate {
+			if err = rateMatchIn(q, ratedID, map[int]bool{scope: true}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Helper function
+//
+// Clears every rating and replays each match result in the order the matches finished
+// Any matches given are left out, for when their result is about to be removed
+func recomputeRatings(q queryer, excludeMatchIDs ...int) error {
+	_, err := q.Exec(`with history as (DELETE FROM rating_history) DELETE FROM player_rating`)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement := `SELECT match.id FROM match_result
+	JOIN match ON match.id = match_result.match_id
+	WHERE match.id <> ALL(COALESCE($1::int[], '{}'))
+	ORDER BY COALESCE(match.end_date, match.start_date), match.id`
+	rows, err := q.Query(sqlStatement, pq.Array(excludeMatchIDs))
+	if err != nil {
+		return err
+	}
+
+	matchIDs := []int{}
+	for rows.Next() {
+		var matchID int
+		if err = rows.Scan(&matchID); err != nil {
+			rows.Close()
+			return err
+		}
+		matchIDs = append(matchIDs, matchID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, matchID := range matchIDs {
+		if err = rateMatch(q, matchID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Endpoint: /ratings
+//
+// Returns every player's global rating, highest first
+func getRatingLeaderboard(c *gin.Context) {
+	queryRatings(c, globalRatings)
+}
+
+// Endpoint: /comps/:id/ratings
+//
+// Returns the ratings of the comp's players from matches in the comp, highest first
+func getCompRatings(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	queryRatings(c, compID)
+}
+
+// Helper function
+//
+// Responds with the leaderboard for the comp, or the global leaderboard for comp 0
+func queryRatings(c *gin.Context, compID int) {
+	sqlStatement := `SELECT player.id, first_name, last_name, rating, deviation, volatility, matches
+	FROM player_rating
+	JOIN player ON player.id = player_rating.player_id
+	WHERE comp_id = $1
+	ORDER BY rating DESC, deviation, player.id`
+	rows, err := db.Query(sqlStatement, compID)
+	if handleError(err, c) {
+		return
+	}
+	defer rows.Close()
+
+	var response struct {
+		Ratings []PlayerRating `json:"ratings"`
+	}
+	response.Ratings = []PlayerRating{}
+	for rows.Next() {
+		var r PlayerRating
+		err = rows.Scan(&r.Player.Id, &r.Player.FirstName, &r.Player.LastName, &r.Rating.Rating, &r.Deviation, &r.Volatility, &r.Matches)
+		if handleError(err, c) {
+			return
+		}
+		r.Rank = len(response.Ratings) + 1
+		r.Provisional = r.Deviation > provisionalDeviation
+		response.Ratings = append(response.Ratings, r)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Endpoint: /players/:id/ratings
+//
+// Returns the player's rating history, oldest first, globally or for one comp with compID
+func getRatingHistory(c *gin.Context) {
+	param := c.Param("id")
+	playerID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		CompID int `form:"compID"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	sqlStatement := `SELECT match_id, rating_before, deviation_before, rating, deviation, volatility, created_at
+	FROM rating_history
+	WHERE player_id = $1 AND comp_id = $2
+	ORDER BY id`
+	rows, err := db.Query(sqlStatement, playerID, request.CompID)
+	if handleError(err, c) {
+		return
+	}
+	defer rows.Close()
+
+	var response struct {
+		Current rating.Rating  `json:"current"`
+		History []RatingChange `json:"history"`
+	}
+	response.Current = rating.Default()
+	response.History = []RatingChange{}
+	for rows.Next() {
+		var change RatingChange
+		err = rows.Scan(&change.MatchID, &change.Before.Rating, &change.Before.Deviation, &change.After.Rating,
+			&change.After.Deviation, &change.After.Volatility, &change.CreatedAt)
+		if handleError(err, c) {
+			return
+		}
+		response.Current = change.After
+		response.History = append(response.History, change)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Recomputes every rating from the match results in one transaction, run with `tennis-api recompute-ratings`
+func runRecomputeRatings() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = recomputeRatings(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
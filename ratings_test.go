@@ -0,0 +1,227 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// Schema for the tables rating touches, created in a throwaway schema for each test
+const ratingTestSchema = `
+CREATE TABLE player (id serial PRIMARY KEY, first_name text, last_name text, is_admin boolean DEFAULT false);
+CREATE TABLE match (id serial PRIMARY KEY, comp_id int, start_date timestamptz, end_date timestamptz);
+CREATE TABLE match_participant (match_id int, player_id int, team int, serve_order int);
+CREATE TABLE match_result (match_id int PRIMARY KEY, winner_id int, reason text);
+CREATE TABLE player_rating (player_id int, comp_id int, rating float8, deviation float8, volatility float8, matches int,
+	updated_at timestamptz, PRIMARY KEY (player_id, comp_id));
+CREATE TABLE rating_history (id serial PRIMARY KEY, player_id int, comp_id int, match_id int, rating_before float8,
+	deviation_before float8, volatility_before float8, rating float8, deviation float8, volatility float8, created_at timestamptz);
+`
+
+// Points db at an empty schema in the database named by TEST_DATABASE_URL, skipping the test without one
+func useTestDatabase(t *testing.T, schema string) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	testDB, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One connection so the search path applies to every query
+	testDB.SetMaxOpenConns(1)
+
+	for _, statement := range []string{`DROP SCHEMA IF EXISTS tennis_test CASCADE`, `CREATE SCHEMA tennis_test`,
+		`SET search_path TO tennis_test`, schema} {
+		if _, err = testDB.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := db
+	db = testDB
+	t.Cleanup(func() {
+		db = previous
+		testDB.Exec(`DROP SCHEMA tennis_test CASCADE`)
+		testDB.Close()
+	})
+}
+
+func TestRunRecomputeRatingsReplaysEveryMatch(t *testing.T) {
+	useTestDatabase(t, ratingTestSchema)
+
+	setup := []string{
+		`INSERT INTO player (id, first_name, last_name) VALUES (1, 'A', 'A'), (2, 'B', 'B'), (3, 'C', 'C')`,
+		`INSERT INTO match (id, comp_id, start_date, end_date) VALUES
+			(1, 7, '2021-01-01', '2021-01-01'), (2, 7, '2021-01-02', '2021-01-02'),
+			(3, NULL, '2021-01-03', '2021-01-03'), (4, 7, '2021-01-04', NULL)`,
+		`INSERT INTO match_participant (match_id, player_id, team, serve_order) VALUES
+			(1, 1, 1, 1), (1, 2, 2, 2), (2, 2, 1, 1), (2, 3, 2, 2), (3, 1, 1, 1), (3, 3, 2, 2), (4, 1, 1, 1), (4, 2, 2, 2)`,
+		`INSERT INTO match_result (match_id, winner_id, reason) VALUES (1, 1, 'completed'), (2, 3, 'completed'), (3, 1, 'retired')`,
+		// A stale rating that the recompute must throw away
+		`INSERT INTO player_rating VALUES (1, 0, 2000, 50, 0.06, 9, current_timestamp)`,
+	}
+	for _, statement := range setup {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := runRecomputeRatings(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each completed match rates both players globally, and again in its comp if it has one
+	tests := []struct {
+		matchID int
+		rows    int
+	}{
+		{1, 4},
+		{2, 4},
+		{3, 2},
+		{4, 0},
+	}
+	for _, test := range tests {
+		var rows int
+		err := db.QueryRow(`SELECT COUNT(*) FROM rating_history WHERE match_id = $1`, test.matchID).Scan(&rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows != test.rows {
+			t.Errorf("match %d has %d rating changes, want %d", test.matchID, rows, test.rows)
+		}
+	}
+
+	var matches int
+	err := db.QueryRow(`SELECT matches FROM player_rating WHERE player_id = 1 AND comp_id = 0`).Scan(&matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches != 2 {
+		t.Errorf("player 1 has %d global rated matches, want 2", matches)
+	}
+
+	// Leaving a match out replays the rest
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err = recomputeRatings(tx, 1); err != nil {
+		t.Fatal(err)
+	}
+	var rated int
+	err = tx.QueryRow(`SELECT COUNT(DISTINCT match_id) FROM rating_history`).Scan(&rated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rated != 2 {
+		t.Errorf("%d matches rated with match 1 left out, want 2", rated)
+	}
+}
+
+func TestUnrateMatchRatesAffectedMatchesAgain(t *testing.T) {
+	useTestDatabase(t, ratingTestSchema)
+
+	// Match 1 affects match 2 through player 2 and match 3 through player 1, match 4 between players 4 and 5 is unaffected
+	setup := []string{
+		`INSERT INTO player (id, first_name, last_name) VALUES (1, 'A', 'A'), (2, 'B', 'B'), (3, 'C', 'C'), (4, 'D', 'D'), (5, 'E', 'E')`,
+		`INSERT INTO match (id, comp_id, start_date, end_date) VALUES
+			(1, 7, '2021-01-01', '2021-01-01'), (2, 7, '2021-01-02', '2021-01-02'),
+			(3, NULL, '2021-01-03', '2021-01-03'), (4, 7, '2021-01-04', '2021-01-04')`,
+		`INSERT INTO match_participant (match_id, player_id, team, serve_order) VALUES
+			(1, 1, 1, 1), (1, 2, 2, 2), (2, 2, 1, 1), (2, 3, 2, 2), (3, 1, 1, 1), (3, 3, 2, 2), (4, 4, 1, 1), (4, 5, 2, 2)`,
+		`INSERT INTO match_result (match_id, winner_id, reason) VALUES (1, 1, 'completed'), (2, 3, 'completed'), (3, 1, 'completed'),
+			(4, 5, 'completed')`,
+	}
+	for _, statement := range setup {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runRecomputeRatings(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ratings left by unrating match 1 should be the ones a full recompute without it gives
+	ratings := func(q queryer) map[[2]int]float64 {
+		rows, err := q.Query(`SELECT player_id, comp_id, rating FROM player_rating WHERE matches > 0`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		ratings := map[[2]int]float64{}
+		for rows.Next() {
+			var playerID, compID int
+			var r float64
+			if err = rows.Scan(&playerID, &compID, &r); err != nil {
+				t.Fatal(err)
+			}
+			ratings[[2]int{playerID, compID}] = r
+		}
+		return ratings
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = recomputeRatings(tx, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := ratings(tx)
+	tx.Rollback()
+
+	var unaffected []int
+	rows, err := db.Query(`SELECT id FROM rating_history WHERE match_id = 4 ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		unaffected = append(unaffected, id)
+	}
+	rows.Close()
+
+	if err = unrateMatch(db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	got := ratings(db)
+	if len(got) != len(want) {
+		t.Errorf("got %d ratings, want %d", len(got), len(want))
+	}
+	for key, r := range want {
+		if math.Abs(got[key]-r) > 1e-9 {
+			t.Errorf("player %d in comp %d rated %f, want %f", key[0], key[1], got[key], r)
+		}
+	}
+
+	var kept int
+	err = db.QueryRow(`SELECT COUNT(*) FROM rating_history WHERE id = ANY($1)`, pq.Array(unaffected)).Scan(&kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept != len(unaffected) || kept != 4 {
+		t.Errorf("%d of match 4's %d rating changes kept, it should not have been rated again", kept, len(unaffected))
+	}
+}
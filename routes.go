@@ -15,11 +15,14 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"tennis-api/scoring"
+
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -107,20 +110,34 @@ func updateCompInvite(c *gin.Context) {
 	var sqlStatement string
 
 	if accept {
-		sqlStatement = `UPDATE comp_reg SET reg_date=current_timestamp, pending=false where player_id=$1 AND comp_id=$2`
+		sqlStatement = `UPDATE comp_reg SET reg_date=current_timestamp, pending=false where player_id=$1 AND comp_id=$2 AND pending=true`
 	} else {
 		sqlStatement = `DELETE FROM comp_reg where player_id=$1 AND comp_id=$2 AND pending=true`
 	}
 
-	_, err = db.Exec(sqlStatement, inviteID, compID)
+	res, err := db.Exec(sqlStatement, inviteID, compID)
 	if err != nil {
 		println(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	// Nothing changed when there was no pending invite, so nobody is told the player joined
+	updated, err := res.RowsAffected()
+	if err != nil {
+		println(err.Error())
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
 	c.Status(http.StatusOK)
 
+	if accept && updated > 0 {
+		playerID, _ := strconv.Atoi(inviteID)
+		compID, _ := strconv.Atoi(compID)
+		firePlayerWebhooks(compID, playerID, WebhookInviteAccepted, WebhookPlayerJoined)
+	}
+
 }
 
 // Helper function
@@ -167,257 +184,1054 @@ func createComp(c *gin.Context) {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
-	comp.Name = &compDetails.CompName
-	comp.IsPrivate = compDetails.IsPrivate
-	comp.CreatorID = &compDetails.CreatorId
-	c.JSON(http.StatusCreated, comp)
+	comp.Name = &compDetails.CompName
+	comp.IsPrivate = compDetails.IsPrivate
+	comp.CreatorID = &compDetails.CreatorId
+	c.JSON(http.StatusCreated, comp)
+	firePlayerWebhooks(*comp.Id, compDetails.CreatorId, WebhookPlayerJoined)
+
+}
+
+// Endpoint: /matches/:id/score
+//
+// Updates the score for the match, creates new points, games or sets as necessary
+// Returns a Score Object with the current score, pointNum and newServer are null when the match is finished
+//
+// The request must carry the match version it was made against, if the match has changed since
+// nothing is scored and 409 is returned with the current score
+func scoreMatch(c *gin.Context) {
+
+	param := c.Param("id")
+	matchID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		PointRequest
+		Version *int `form:"version" binding:"required"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	version, current, err := lockMatchVersion(tx, matchID, request.Version)
+	if handleError(err, c) {
+		return
+	}
+
+	// Points can only be scored while the match is being played, the first point starts a scheduled match
+	status, err := getMatchStatus(tx, matchID)
+	if handleError(err, c) {
+		return
+	} else if status != StatusScheduled && status != StatusInProgress {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Match is " + status})
+		return
+	}
+
+	// Replay the points already scored
+	state, teams, err := getMatchState(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	if !current || request.PointNum != state.PointNumber() {
+		response := ScoreConflictResponse{Message: "Match has changed since the request was made", Current: getScoreResponse(state, teams)}
+		response.Current.Version = version
+		c.JSON(http.StatusConflict, response)
+		return
+	}
+
+	if teams.side(request.WinnerID) < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Winner is not playing in this match"})
+		return
+	} else if message := checkPointDetail(&request.PointRequest, state, teams); message != "" {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: message})
+		return
+	}
+
+	println("Updating current point")
+
+	if status == StatusScheduled {
+		err = setMatchStatus(tx, matchID, StatusInProgress)
+		if handleError(err, c) {
+			return
+		}
+	}
+
+	err = addPoint(tx, matchID, state, teams, request.PointRequest)
+	if handleError(err, c) {
+		return
+	}
+
+	response := getScoreResponse(state, teams)
+	response.Version, err = bumpMatchVersion(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+	publishMatchEvent(matchID, livePointEvent(state))
+	fireMatchWebhooks(matchID, pointWebhookEvents(state)...)
+
+}
+
+// Endpoint: /matches/:id/points
+//
+// Uploads points scored while the client was offline, as a JSON list in the order they were played
+// The points are checked against the match and applied together, if any point conflicts none are applied
+// Responds with whether each point was accepted and the current score, with 409 if there was a conflict
+// in which case the points before the conflict are marked not applied
+func scoreMatchPoints(c *gin.Context) {
+	param := c.Param("id")
+	matchID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		Points  []PointRequest `json:"points" binding:"required,dive"`
+		Version *int           `json:"version"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	version, current, err := lockMatchVersion(tx, matchID, request.Version)
+	if handleError(err, c) {
+		return
+	}
+
+	status, err := getMatchStatus(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	state, teams, err := getMatchState(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	// The score as it was before the batch, returned if nothing is applied
+	before := getScoreResponse(state, teams)
+	before.Version = version
+
+	if !current {
+		c.JSON(http.StatusConflict, ScoreConflictResponse{Message: "Match has changed since the request was made", Current: before})
+		return
+	}
+
+	var response BatchPointResponse
+	var conflict string
+	var lastScoredAt *time.Time
+	for _, point := range request.Points {
+		result := BatchPointResult{PointNum: point.PointNum, Status: BatchSkipped}
+		if conflict != "" {
+			response.Points = append(response.Points, result)
+			continue
+		}
+
+		// Check the point follows on from the match as it stands, including the points before it in the batch
+		if status != StatusScheduled && status != StatusInProgress {
+			conflict = "Match is " + status
+		} else if state.Complete() {
+			conflict = "Match is already over"
+		} else if point.PointNum != state.PointNumber() {
+			conflict = fmt.Sprintf("Expected point %d", state.PointNumber())
+		} else if teams.side(point.WinnerID) < 0 {
+			conflict = "Winner is not playing in this match"
+		} else if message := checkPointDetail(&point, state, teams); message != "" {
+			conflict = message
+		} else if point.ScoredAt != nil && lastScoredAt != nil && point.ScoredAt.Before(*lastScoredAt) {
+			conflict = "Points must be in the order they were played"
+		}
+
+		if conflict != "" {
+			result.Status, result.Message = BatchConflict, conflict
+			response.Points = append(response.Points, result)
+			continue
+		}
+
+		if status == StatusScheduled {
+			status = StatusInProgress
+			err = setMatchStatus(tx, matchID, status)
+			if handleError(err, c) {
+				return
+			}
+		}
+
+		err = addPoint(tx, matchID, state, teams, point)
+		if handleError(err, c) {
+			return
+		}
+		if point.ScoredAt != nil {
+			lastScoredAt = point.ScoredAt
+		}
+
+		result.Status = BatchAccepted
+		response.Points = append(response.Points, result)
+	}
+
+	// Nothing is applied if any point conflicts, the deferred rollback undoes the points before it
+	if conflict != "" {
+		for i := range response.Points {
+			if response.Points[i].Status == BatchAccepted {
+				response.Points[i].Status = BatchNotApplied
+			}
+		}
+		response.Current = before
+		c.JSON(http.StatusConflict, response)
+		return
+	}
+
+	response.Applied = true
+	response.Current = getScoreResponse(state, teams)
+	response.Current.Version, err = bumpMatchVersion(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+	publishMatchEvent(matchID, livePointEvent(state))
+	fireMatchWebhooks(matchID, pointWebhookEvents(state)...)
+}
+
+// Returns the live event for a point, the end event if it won the match
+func livePointEvent(state *scoring.MatchState) string {
+	if state.Complete() {
+		return LiveEnd
+	}
+	return LivePoint
+}
+
+// Returns the webhook events for a point, including match completed if it won the match
+func pointWebhookEvents(state *scoring.MatchState) []string {
+	if state.Complete() {
+		return []string{WebhookPointScored, WebhookMatchCompleted}
+	}
+	return []string{WebhookPointScored}
+}
+
+// Helper function
+//
+// Adds the point to the match's history and records it, the winner can be any player on the winning team
+func addPoint(q queryer, matchID int, state *scoring.MatchState, teams matchTeams, point PointRequest) error {
+	winnerID := teams.lead(teams.side(point.WinnerID)).Id
+	event := PointEvent{Action: ActionScore, PointNum: &point.PointNum, WinnerID: &winnerID, Faults: point.Faults,
+		Lets: point.Lets, Ace: point.Ace, UnforcedError: point.UnforcedError, ScoredAt: point.ScoredAt, PointDetail: point.PointDetail}
+	err := appendPointEvent(q, matchID, &event)
+	if err != nil {
+		return err
+	}
+
+	return recordPoint(q, matchID, state, teams, event)
+}
+
+// Helper function
+//
+// Runs everything that follows from a match finishing, once its result is stored
+// The match is rated, its winner moves on in the comp's draw and a ladder challenge played in it is settled
+func matchCompleted(q queryer, matchID int) error {
+	err := rateMatch(q, matchID)
+	if err != nil {
+		return err
+	}
+	err = advanceBracket(q, matchID)
+	if err != nil {
+		return err
+	}
+	return completeChallenge(q, matchID)
+}
+
+// Helper function
+//
+// Takes back everything matchCompleted did, before the match's result is removed
+func matchReopened(q queryer, matchID int) error {
+	err := unrateMatch(q, matchID)
+	if err != nil {
+		return err
+	}
+	err = retractBracket(q, matchID)
+	if err != nil {
+		return err
+	}
+	return reopenChallenge(q, matchID)
+}
+
+// Helper function
+//
+// Applies the point to the match state and stores it on the current point, along with any game, set or match it completes
+// Then creates the next point, in a new game or set if this point completed one
+func recordPoint(q queryer, matchID int, state *scoring.MatchState, teams matchTeams, event PointEvent) error {
+	pointNum := state.PointNumber()
+	current := state.Sets[len(state.Sets)-1]
+	setNumber, gameNumber, tiebreak := len(state.Sets), current.Games[0]+current.Games[1]+1, state.Tiebreak
+	result, err := state.Apply(teams.side(*event.WinnerID))
+	if err != nil {
+		return err
+	}
+
+	// Update the current point
+	sqlStatement := `UPDATE point SET 
+	faults=$3, 
+	lets=$4,
+	ace=$5,
+	unforced_error=$6,
+	winner_id=$7,
+	serve_number=$8,
+	serve_direction=$9,
+	ending=$10,
+	shot_type=$11,
+	rally_length=$12,
+	striker_id=$13
+	WHERE number=$1 AND match_id=$2
+	RETURNING game_id`
+	var pointGameID sql.NullInt64
+	err = q.QueryRow(sqlStatement, pointNum, matchID, event.Faults, event.Lets, event.Ace, event.UnforcedError, event.WinnerID,
+		event.ServeNumber, event.ServeDirection, event.Ending, event.ShotType, event.RallyLength, event.StrikerID).Scan(&pointGameID)
+	if err != nil {
+		return err
+	}
+
+	// Points scored before games were stored have no game, so the game and set are created for them
+	gameID := int(pointGameID.Int64)
+	if !pointGameID.Valid {
+		gameID, err = addPointGame(q, matchID, pointNum, setNumber, gameNumber, tiebreak)
+		if err != nil {
+			return err
+		}
+	}
+
+	var setID int
+	if result.GameWon {
+		sqlStatement = `UPDATE game SET winner_id=$2 WHERE id=$1 RETURNING set_id`
+		err = q.QueryRow(sqlStatement, gameID, event.WinnerID).Scan(&setID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if result.SetWon {
+		sqlStatement = `UPDATE set SET winner_id=$2 WHERE id=$1`
+		_, err = q.Exec(sqlStatement, setID, event.WinnerID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if result.MatchWon {
+		// match over
+		// create new match result with winner
+		sqlStatement = `INSERT INTO match_result (match_id, winner_id, reason)
+						VALUES
+						($1, $2, $3)`
+		_, err = q.Exec(sqlStatement, matchID, event.WinnerID, EndCompleted)
+		if err != nil {
+			return err
+		}
+
+		// Update match for end date
+		sqlStatement = `UPDATE match SET end_date=current_timestamp, status='completed' WHERE id = $1`
+		_, err = q.Exec(sqlStatement, matchID)
+		if err != nil {
+			return err
+		}
+
+		return matchCompleted(q, matchID)
+	}
+
+	newPointNum := state.PointNumber()
+	newServer, newReceiver := teams.serverReceiver(state)
+
+	set := state.Sets[len(state.Sets)-1]
+	switch {
+	case result.SetWon:
+		return newSetGamePoint(q, matchID, len(state.Sets), newPointNum, newServer, newReceiver, state.Tiebreak)
+	case result.GameWon:
+		return newGamePoint(q, matchID, setID, set.Games[0]+set.Games[1]+1, newPointNum, newServer, newReceiver, state.Tiebreak)
+	}
+
+	sqlStatement = `INSERT INTO point (number, match_id, game_id, server_id, receiver_id)
+	VALUES ($1, $2, $3, $4, $5)`
+	_, err = q.Exec(sqlStatement, newPointNum, matchID, gameID, newServer, newReceiver)
+	return err
+}
+
+// Endpoint: /matches/:id/end
+//
+// Ends a match before the winning point with a reason, retired, walkover, default or abandoned
+// Abandoned matches have no winner, a winner must be given for every other reason
+// Returns the finished match object, or 409 if a version is given and the match has changed
+func endMatch(c *gin.Context) {
+	param := c.Param("id")
+	matchID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		Reason   string `form:"reason" binding:"required"`
+		WinnerID *int   `form:"winnerID"`
+		Version  *int   `form:"version"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	switch request.Reason {
+	case EndRetired, EndWalkover, EndDefault:
+		if request.WinnerID == nil {
+			c.JSON(http.StatusBadRequest, ErrorResposne{Message: "A winner is needed for a " + request.Reason})
+			return
+		}
+	case EndAbandoned:
+		if request.WinnerID != nil {
+			c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Abandoned matches have no winner"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Reason must be retired, walkover, default or abandoned"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	if !tryLockMatchVersion(c, tx, matchID, request.Version) {
+		return
+	}
+
+	teams, err := getMatchTeams(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	// The result is recorded against the first player of the winning team
+	if request.WinnerID != nil {
+		winner := teams.side(*request.WinnerID)
+		if winner < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Winner is not playing in this match"})
+			return
+		}
+		request.WinnerID = &teams.lead(winner).Id
+	}
+
+	status, err := getMatchStatus(tx, matchID)
+	if handleError(err, c) {
+		return
+	} else if !canChangeStatus(status, StatusCompleted) {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Match is already over"})
+		return
+	}
+
+	sqlStatement := `INSERT INTO match_result (match_id, winner_id, reason)
+	VALUES ($1, $2, $3)`
+	_, err = tx.Exec(sqlStatement, matchID, request.WinnerID, request.Reason)
+	if handleError(err, c) {
+		return
+	}
+
+	sqlStatement = `UPDATE match SET end_date=current_timestamp, status='completed' WHERE id = $1`
+	_, err = tx.Exec(sqlStatement, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	err = matchCompleted(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	_, err = bumpMatchVersion(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	getMatchFromID(c)
+	publishMatchEvent(matchID, LiveEnd)
+	fireMatchWebhooks(matchID, WebhookMatchCompleted)
+}
+
+// Endpoint: /matches/:id/suspend
+//
+// Suspends a match in progress, e.g. for a rain delay, no points can be scored until it is resumed
+func suspendMatch(c *gin.Context) {
+	changeMatchStatus(c, StatusSuspended)
+}
+
+// Endpoint: /matches/:id/resume
+//
+// Resumes a suspended match
+func resumeMatch(c *gin.Context) {
+	changeMatchStatus(c, StatusInProgress)
+}
+
+// Helper function
+//
+// Moves the match in the request to the new status if the transition is allowed
+// Responds with the match object, or 409 if the match can't move to the new status or has changed since the given version
+func changeMatchStatus(c *gin.Context, to string) {
+	param := c.Param("id")
+	matchID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		Version *int `form:"version"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	if !tryLockMatchVersion(c, tx, matchID, request.Version) {
+		return
+	}
+
+	status, err := getMatchStatus(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	if !canChangeStatus(status, to) {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Match is " + status})
+		return
+	}
+
+	err = setMatchStatus(tx, matchID, to)
+	if handleError(err, c) {
+		return
+	}
+
+	_, err = bumpMatchVersion(tx, matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	getMatchFromID(c)
+	publishMatchEvent(matchID, LiveStatus)
+}
+
+// Returns true if a match is allowed to move from one status to another
+//
+// Scheduled matches start when the first point is scored, matches in progress can be suspended and resumed,
+// and any match that isn't completed can be completed
+func canChangeStatus(from, to string) bool {
+	switch to {
+	case StatusInProgress:
+		return from == StatusScheduled || from == StatusSuspended
+	case StatusSuspended:
+		return from == StatusInProgress
+	case StatusCompleted:
+		return from != StatusCompleted
+	}
+	return false
+}
+
+// Returns the status of the match
+func getMatchStatus(q queryer, matchID int) (string, error) {
+	sqlStatement := `SELECT ` + matchStatusColumn + ` FROM match
+	LEFT JOIN match_result ON match.id = match_result.match_id
+	WHERE match.id = $1`
+
+	var status string
+	err := q.QueryRow(sqlStatement, matchID).Scan(&status)
+	return status, err
+}
+
+// Helper function
+//
+// Updates the status of the match
+func setMatchStatus(q queryer, matchID int, status string) error {
+	sqlStatement := `UPDATE match SET status=$2 WHERE id = $1`
+	_, err := q.Exec(sqlStatement, matchID, status)
+	return err
+}
+
+// Helper function
+//
+// Builds a match format from a preset, defaulting to standard, and any custom fields in the request
+// A tiebreakAt of 0 means sets are played as advantage sets with no tiebreak
+//
+// Returns an error describing why the format is invalid
+func buildMatchFormat(request MatchFormatRequest) (MatchFormat, error) {
+	format := MatchFormat{Name: request.Format}
+	if format.Name == "" {
+		format.Name = "standard"
+	}
+
+	var ok bool
+	format.Format, ok = scoring.Preset(format.Name)
+	if !ok {
+		return format, fmt.Errorf("unknown match format %s", format.Name)
+	}
+
+	custom := false
+	if request.BestOf != nil {
+		format.BestOf, custom = *request.BestOf, true
+	}
+	if request.SetGames != nil {
+		format.SetGames, custom = *request.SetGames, true
+	}
+	if request.TiebreakAt != nil {
+		format.TiebreakAt, custom = request.TiebreakAt, true
+		if *request.TiebreakAt == 0 {
+			format.TiebreakAt = nil
+		}
+	}
+	if request.TiebreakPoints != nil {
+		format.TiebreakPoints, custom = *request.TiebreakPoints, true
+	}
+	if request.SuddenDeathTiebreak != nil {
+		format.SuddenDeathTiebreak, custom = *request.SuddenDeathTiebreak, true
+	}
+	if request.NoAd != nil {
+		format.NoAd, custom = *request.NoAd, true
+	}
+	if request.MatchTiebreak != nil {
+		format.MatchTiebreak, custom = *request.MatchTiebreak, true
+	}
+	if request.MatchTiebreakPoints != nil {
+		format.MatchTiebreakPoints, custom = *request.MatchTiebreakPoints, true
+	}
+	if custom {
+		format.Name = "custom"
+	}
+
+	return format, format.Validate()
+}
+
+// Returns the format the match is played in
+// Matches created before formats were stored have no format and are played as the standard best of 3
+func getMatchFormat(q queryer, matchID int) (*MatchFormat, error) {
+	sqlStatement := `SELECT format_name, best_of, set_games, tiebreak_at, tiebreak_points,
+	sudden_death_tiebreak, no_ad, match_tiebreak, match_tiebreak_points
+	FROM match
+	WHERE id = $1`
+
+	var name *string
+	var bestOf, setGames, tiebreakAt, tiebreakPoints, matchTiebreakPoints *int
+	var suddenDeathTiebreak, noAd, matchTiebreak *bool
+	err := q.QueryRow(sqlStatement, matchID).Scan(&name, &bestOf, &setGames, &tiebreakAt, &tiebreakPoints,
+		&suddenDeathTiebreak, &noAd, &matchTiebreak, &matchTiebreakPoints)
+	if err != nil {
+		return nil, err
+	}
+
+	format := MatchFormat{Name: "standard"}
+	format.Format, _ = scoring.Preset(format.Name)
+	if name == nil {
+		return &format, nil
+	}
+
+	format.Name = *name
+	format.TiebreakAt = tiebreakAt
+	for _, field := range []struct {
+		value *int
+		to    *int
+	}{{bestOf, &format.BestOf}, {setGames, &format.SetGames}, {tiebreakPoints, &format.TiebreakPoints}, {matchTiebreakPoints, &format.MatchTiebreakPoints}} {
+		if field.value != nil {
+			*field.to = *field.value
+		}
+	}
+	for _, field := range []struct {
+		value *bool
+		to    *bool
+	}{{suddenDeathTiebreak, &format.SuddenDeathTiebreak}, {noAd, &format.NoAd}, {matchTiebreak, &format.MatchTiebreak}} {
+		if field.value != nil {
+			*field.to = *field.value
+		}
+	}
+
+	return &format, nil
+}
+
+// The players on each team of a match in serving order, one each in singles and two each in doubles
+// Team 1 is side 0 of the match state and team 2 is side 1
+type matchTeams [2][]Player
+
+// Returns the side of the match the player is on, -1 if they aren't playing
+func (t matchTeams) side(playerID int) int {
+	for side, team := range t {
+		for _, player := range team {
+			if player.Id == playerID {
+				return side
+			}
+		}
+	}
+	return -1
+}
+
+// Returns the first player of the team on the side
+// The first player represents their team as the winner of points, games, sets and the match
+func (t matchTeams) lead(side int) *Player {
+	return &t[side][0]
+}
+
+// Returns the ids of the players serving and receiving the next point
+//
+// Service rotates through every player by game, alternating between teams
+// The receiving team alternates which player receives every point
+func (t matchTeams) serverReceiver(state *scoring.MatchState) (int, int) {
+	turn := state.ServeTurn()
+	serving, receiving := t[state.Server()], t[1-state.Server()]
+
+	pointInGame := state.Game[0] + state.Game[1]
+	return serving[(turn/2)%len(serving)].Id, receiving[pointInGame%len(receiving)].Id
+}
+
+// Helper function
+//
+// Replays the points in the match's history through the scoring engine
+//
+// Returns the match state along with both teams
+func getMatchState(q queryer, matchID int) (*scoring.MatchState, matchTeams, error) {
+	state, teams, points, err := getMatchStart(q, matchID)
+	if err != nil {
+		return nil, teams, err
+	}
+
+	for _, point := range points {
+		_, err = state.Apply(teams.side(*point.WinnerID))
+		if err != nil {
+			return nil, teams, err
+		}
+	}
+
+	return state, teams, nil
+}
+
+// Helper function
+//
+// Returns the match state before the first point, both teams and the points that stand in the match's history,
+// for callers that replay the points themselves
+func getMatchStart(q queryer, matchID int) (*scoring.MatchState, matchTeams, []PointEvent, error) {
+	teams, err := getMatchTeams(q, matchID)
+	if err != nil {
+		return nil, teams, nil, err
+	}
+
+	format, err := getMatchFormat(q, matchID)
+	if err != nil {
+		return nil, teams, nil, err
+	}
+
+	var firstServer int
+	err = q.QueryRow(`SELECT server_id FROM point WHERE match_id = $1 AND number = 1`, matchID).Scan(&firstServer)
+	if err != nil {
+		return nil, teams, nil, err
+	}
+
+	events, err := getPointEvents(q, matchID)
+	if err != nil {
+		return nil, teams, nil, err
+	}
+
+	points, _ := replayPointEvents(events)
+	return scoring.NewMatch(format.Format, teams.side(firstServer)), teams, points, nil
+}
+
+// Helper function
+//
+// Creates a new set within the match, along with its first game and point
+func newSetGamePoint(q queryer, matchID, setNumber, pointNum, serverID, receiverID int, isTiebreak bool) error {
+	sqlStatement := `INSERT INTO set (match_id, number)
+	VALUES ($1, $2)
+	RETURNING id`
+
+	var setID int
+	err := q.QueryRow(sqlStatement, matchID, setNumber).Scan(&setID)
+	if err != nil {
+		return err
+	}
 
+	return newGamePoint(q, matchID, setID, 1, pointNum, serverID, receiverID, isTiebreak)
 }
 
-// Endpoint: /matches/:id/score
+// Helper function
 //
-// Updates the score for the match, creates new points, games or sets as necessary
-// Returns a Score Object if game is still in progress, returns empty body when game finished
-func scoreMatch(c *gin.Context) {
-
-	param := c.Param("id")
-	matchID, err := strconv.Atoi(param)
-	if handleError(err, c) {
-		return
-	}
+// Creates the game the point is played in, and its set if that doesn't exist yet, and moves the point into it
+//
+// Returns the id of the game
+func addPointGame(q queryer, matchID, pointNum, setNumber, gameNumber int, isTiebreak bool) (int, error) {
+	sqlStatement := `WITH new_set AS (
+		INSERT INTO set (match_id, number)
+		SELECT $1, $2
+		WHERE NOT EXISTS (SELECT 1 FROM set WHERE match_id = $1 AND number = $2)
+		RETURNING id
+	), new_game AS (
+		INSERT INTO game (set_id, number, server_id, receiver_id, is_tiebreak)
+		SELECT COALESCE((SELECT id FROM new_set), (SELECT id FROM set WHERE match_id = $1 AND number = $2)), $3, server_id, receiver_id, $5
+		FROM point
+		WHERE match_id = $1 AND number = $4
+		RETURNING id
+	)
+	UPDATE point SET game_id = (SELECT id FROM new_game)
+	WHERE match_id = $1 AND number = $4
+	RETURNING game_id`
+
+	var gameID int
+	err := q.QueryRow(sqlStatement, matchID, setNumber, gameNumber, pointNum, isTiebreak).Scan(&gameID)
+	return gameID, err
+}
 
-	var request struct {
-		PointNum      int   `form:"pointNum" binding:"required"`
-		Faults        int   `form:"faults"`
-		Lets          int   `form:"lets"`
-		Ace           *bool `form:"ace"`
-		UnforcedError *bool `form:"unforcedError"`
-		WinnerID      int   `form:"winnerID" binding:"required"`
-	}
+// Helper function
+//
+// Creates a new game within the set, along with its first point
+func newGamePoint(q queryer, matchID, setID, gameNumber, pointNum, serverID, receiverID int, isTiebreak bool) error {
+	sqlStatement := `WITH new_game AS (
+		INSERT INTO game (set_id, number, server_id, receiver_id, is_tiebreak)
+		VALUES ($2, $3, $5, $6, $7)
+		RETURNING id
+	)
+	INSERT INTO point (number, match_id, game_id, server_id, receiver_id)
+	VALUES ($4, $1, (SELECT id FROM new_game), $5, $6)`
+
+	_, err := q.Exec(sqlStatement, matchID, setID, gameNumber, pointNum, serverID, receiverID, isTiebreak)
+	return err
+}
 
+// Helper function
+//
+// Builds a score response from the match state, pointNum and newServer are nil once the match is over
+func getScoreResponse(state *scoring.MatchState, teams matchTeams) ScoreResponse {
 	var response ScoreResponse
 
-	if !tryGetRequest(c, &request) {
-		return
+	if points := state.Points(); len(points) > 0 {
+		lastWinner := points[len(points)-1]
+		response.LastPointWinnerPts = state.PointsWon[lastWinner]
+		response.OtherPlayerPoints = state.PointsWon[1-lastWinner]
 	}
 
-	println("Updating current point")
+	sets, setsWon := getSetScores(state, teams)
+	response.Sets = sets
+	response.SetsWon = &setsWon
 
-	// Update the current point
-	sqlStatement := `UPDATE point SET 
-	faults=$3, 
-	lets=$4,
-	ace=$5,
-	unforced_error=$6,
-	winner_id=$7
-	WHERE number=$1 AND match_id=$2`
-	_, err = db.Exec(sqlStatement, request.PointNum, matchID, request.Faults, request.Lets, request.Ace, request.UnforcedError, request.WinnerID)
-	if handleError(err, c) {
-		return
+	if !state.Complete() {
+		pointNum := state.PointNumber()
+		newServer, newReceiver := teams.serverReceiver(state)
+
+		response.Point = &pointNum
+		response.NewServer = &newServer
+		response.NewReceiver = &newReceiver
+		response.Game = &GameScore{Player1: state.GameCall(0), Player2: state.GameCall(1)}
+		response.Tiebreak = state.Tiebreak
 	}
 
-	println("Checking if match is over")
+	return response
+}
 
-	// Get players wins
-	sqlStatement = `
-	SELECT player.id, (SELECT COUNT(winner_id) FROM point
-			WHERE winner_id = player.id and match_id = $1) as wins
-	FROM player
-	JOIN match_participant mp ON player_id = player.id
-	WHERE mp.match_id = $1;`
-	rows, err := db.Query(sqlStatement, matchID)
-	if handleError(err, c) {
-		return
-	}
+// Returns the games won by each team in every set of the match, and the number of sets each team has won
+func getSetScores(state *scoring.MatchState, teams matchTeams) ([]SetScore, MatchScore) {
+	sets := []SetScore{}
+	for i, set := range state.Sets {
+		setScore := SetScore{Number: i + 1, Player1: set.Games[0], Player2: set.Games[1]}
 
-	var winnerWins, otherWins int
-	for rows.Next() {
-		var wins, id int
-		err = rows.Scan(&id, &wins)
-		if err != nil {
-			println(err.Error())
+		if set.Winner != scoring.NoWinner {
+			setScore.WinnerID = &teams.lead(set.Winner).Id
 		}
 
-		if id == request.WinnerID {
-			winnerWins = wins
-		} else {
-			otherWins = wins
+		if set.Tiebreak != nil {
+			setScore.Tiebreak = &MatchScore{Player1: set.Tiebreak[0], Player2: set.Tiebreak[1]}
 		}
+
+		sets = append(sets, setScore)
+	}
+
+	// The current tiebreak has not been added to its set yet
+	if state.Tiebreak {
+		sets[len(sets)-1].Tiebreak = &MatchScore{Player1: state.Game[0], Player2: state.Game[1]}
 	}
 
-	// Get match info, points to win by, min points, server and receiver
-	sqlStatement = `SELECT min_points, win_by, server_id, receiver_id FROM point
-					JOIN match on point.match_id = match.id
-					WHERE point.match_id = $1 and number = $2`
+	setsWon := state.SetsWon()
+	return sets, MatchScore{Player1: setsWon[0], Player2: setsWon[1]}
+}
 
-	var curServer, curReceiver, minpoints, winBy int
-	err = db.QueryRow(sqlStatement, matchID, request.PointNum).Scan(&minpoints, &winBy, &curServer, &curReceiver)
+func newMatchInComp(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
 	if handleError(err, c) {
 		return
 	}
 
-	// Check if match over
-	if winnerWins >= minpoints {
-		dif := winnerWins - otherWins
-		if dif >= winBy {
-			// game over
-			// create new match result with winner
-			sqlStatement = `INSERT INTO match_result (match_id, winner_id)
-							VALUES
-							($1, $2)`
-			_, err = db.Exec(sqlStatement, matchID, request.WinnerID)
-			if handleError(err, c) {
-				return
-			}
+	var request struct {
+		StartDate         time.Time `form:"startDate" binding:"required"`
+		ServerID          int       `form:"serverID" binding:"required"`
+		ReceiverID        int       `form:"receiverID" binding:"required"`
+		ServerPartnerID   int       `form:"serverPartnerID"`
+		ReceiverPartnerID int       `form:"receiverPartnerID"`
+		MatchFormatRequest
+	}
 
-			// Update match for end date
-			sqlStatement = `UPDATE match SET end_date=current_timestamp WHERE id = $1`
-			_, err = db.Exec(sqlStatement, matchID)
-			if handleError(err, c) {
-				return
-			}
+	// Get query params into object
+	if !tryGetRequest(c, &request) {
+		return
+	}
 
-			c.JSON(http.StatusOK, response)
+	// Players in serving order, doubles matches serve in turn from the server, receiver, then their partners
+	serveOrder := []int{request.ServerID, request.ReceiverID}
+	if request.ServerPartnerID != 0 || request.ReceiverPartnerID != 0 {
+		if request.ServerPartnerID == 0 || request.ReceiverPartnerID == 0 {
+			c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Doubles matches need a partner for both the server and receiver"})
 			return
-
 		}
+		serveOrder = append(serveOrder, request.ServerPartnerID, request.ReceiverPartnerID)
 	}
 
-	// new point
-
-	var newServer, newReceiver int
-	// Swap server every 2 points
-	if request.PointNum%2 == 0 {
-		newServer = curReceiver
-		newReceiver = curServer
-	} else {
-		newServer = curServer
-		newReceiver = curReceiver
+	format, err := buildMatchFormat(request.MatchFormatRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: err.Error()})
+		return
 	}
 
-	var newPointNum = request.PointNum + 1
-	sqlStatement = `INSERT INTO point (number, match_id, server_id, receiver_id)
-	VALUES ($1, $2, $3, $4)`
-	_, err = db.Exec(sqlStatement, newPointNum, matchID, newServer, newReceiver)
+	tx, err := db.Begin()
 	if handleError(err, c) {
 		return
 	}
+	defer tx.Rollback()
 
-	response = ScoreResponse{Point: &newPointNum, NewServer: &newServer, LastPointWinnerPts: winnerWins, OtherPlayerPoints: otherWins}
-
-	c.JSON(http.StatusOK, response)
-
-}
-
-func newMatchInComp(c *gin.Context) {
-	compID := c.Param("id")
-
-	var request struct {
-		StartDate  time.Time `form:"startDate" binding:"required"`
-		ServerID   int       `form:"serverID" binding:"required"`
-		ReceiverID int       `form:"receiverID" binding:"required"`
-		NumPoints  int       `form:"numPoints" binding:"required"`
-		WinBy      int       `form:"winBy"`
-	}
-
-	// Get query params into object
-	if !tryGetRequest(c, &request) {
+	match, state, teams, err := createMatch(tx, compID, request.StartDate, nil, serveOrder, format)
+	if handleError(err, c) {
 		return
 	}
 
-	// Create new match
-	sqlStatement := `
-		WITH new_match AS (INSERT INTO match (comp_id, start_date,  min_points, win_by) VALUES ($1, $2, $3, $4)
-		RETURNING id)
-		INSERT INTO match_participant
-		VALUES
-		( (SELECT id FROM new_match), $5),
-		( (SELECT id FROM new_match), $6)
-		RETURNING match_id
-		`
-	var match Match
-	err := db.QueryRow(sqlStatement, compID, request.StartDate, request.NumPoints, request.WinBy, request.ServerID, request.ReceiverID).Scan(&match.MatchID)
+	err = tx.Commit()
 	if handleError(err, c) {
 		return
 	}
 
-	// Get players form their id's
-	match.Player1, match.Player2 = getPlayersFromMatch(match.MatchID)
-
 	var response struct {
 		NewPoint ScoreResponse `json:"newPoint"`
 		Match    Match         `json:"match"`
 	}
 
-	sqlStatement = `
-	INSERT INTO point (number, match_id, server_id, receiver_id)
-	VALUES (1, $1, $2, $3)`
-	_, err = db.Exec(sqlStatement, match.MatchID, request.ServerID, request.ReceiverID)
-	if handleError(err, c) {
-		return
-	}
-
-	// FIXME: disgusting code
-	res := ScoreResponse{NewServer: &request.ServerID, OtherPlayerPoints: 0, LastPointWinnerPts: 0}
-	one := 1
-	res.Point = &one
-	response.NewPoint = res
-	match.StartDate = &request.StartDate
+	response.NewPoint = getScoreResponse(state, teams)
 	response.Match = match
 	c.JSON(http.StatusOK, response)
+	fireMatchWebhooks(match.MatchID, WebhookMatchCreated)
 
 }
 
-// Creates a new set, game and point within the match
+// Helper function
 //
-// Returns a Score Reponse containing the point number, game and set ID
-// func newSetGamePoint(c *gin.Context, matchID, serverID, receiverID, setNumber, numPoints, numGames int) *ScoreResponse {
-
-// 	var response ScoreResponse
-
-// 	// Create a new set, game and point
-// 	sqlStatement := `WITH new_set AS (
-// 		INSERT INTO set (match_id, number, num_games)
-// 		VALUES
-// 		($1, $2, $3)
-// 		RETURNING id
-// 		),
-// 		new_game AS (
-// 		INSERT INTO game (set_id, number, server_id, receiver_id, num_points)
-// 		VALUES
-// 		( (SELECT id FROM new_set), 1, $4, $5, $6)
-// 		RETURNING id
-// 		),
-// 		new_point AS (
-// 		INSERT into point (number, game_id, server_id)
-// 		VALUES
-// 		(1,  (SELECT id FROM new_game), $4 )
-// 		RETURNING number
-// 		)
-// 		SELECT new_point.number as pid, new_game.id as gid, new_set.id as sid
-// 		FROM new_point, new_game, new_set`
-
-// 	err := db.QueryRow(sqlStatement, matchID, setNumber, numGames, serverID, receiverID, numPoints).Scan(&response.Point, &response.Game, &response.Set)
-// 	if handleError(err, c) {
-// 		return nil
-// 	}
-
-// 	return &response
-// }
+// Creates a scheduled match in the comp with its players and first point, round is nil for matches outside a schedule
+// serveOrder is the first server, the receiver, then their partners in doubles, the server's team is team 1
+//
+// Returns the new match with the state of its score and its teams
+func createMatch(q queryer, compID int, startDate time.Time, round *int, serveOrder []int, format MatchFormat) (Match, *scoring.MatchState, matchTeams, error) {
+	// Create new match
+	sqlStatement := `INSERT INTO match (comp_id, start_date, round, format_name, best_of, set_games, tiebreak_at, tiebreak_points,
+		sudden_death_tiebreak, no_ad, match_tiebreak, match_tiebreak_points, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, 'scheduled')
+		RETURNING id`
+	var match Match
+	err := q.QueryRow(sqlStatement, compID, startDate, round, format.Name, format.BestOf, format.SetGames, format.TiebreakAt, format.TiebreakPoints,
+		format.SuddenDeathTiebreak, format.NoAd, format.MatchTiebreak, format.MatchTiebreakPoints).Scan(&match.MatchID)
+	if err != nil {
+		return match, nil, matchTeams{}, err
+	}
+
+	// Add the players, the server's team is team 1
+	for i, playerID := range serveOrder {
+		sqlStatement = `INSERT INTO match_participant (match_id, player_id, team, serve_order)
+		VALUES ($1, $2, $3, $4)`
+		_, err = q.Exec(sqlStatement, match.MatchID, playerID, i%2+1, i+1)
+		if err != nil {
+			return match, nil, matchTeams{}, err
+		}
+	}
+
+	// First set, game and point
+	err = newSetGamePoint(q, match.MatchID, 1, 1, serveOrder[0], serveOrder[1], false)
+	if err != nil {
+		return match, nil, matchTeams{}, err
+	}
+
+	// Get players form their id's
+	state, teams, err := getMatchState(q, match.MatchID)
+	if err != nil {
+		return match, nil, teams, err
+	}
+	setMatchTeams(&match, teams)
+
+	match.StartDate = &startDate
+	match.Round = round
+	match.Format = &format
+	match.Status = StatusScheduled
+	return match, state, teams, nil
+}
 
 // Endpoint /matches/:id
 //
 // Delete a match
 func deleteMatchFromID(c *gin.Context) {
-	matchID := c.Param("id")
+	param := c.Param("id")
+	matchID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
 
-	sqlStatement := `with points as (DELETE FROM point WHERE match_id = $1),
-	parts as (DELETE FROM match_participant WHERE match_id = $1),
-	res as (DELETE FROM match_result WHERE match_id = $1)
-	DELETE FROM match WHERE id= $1;`
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
 
-	_, err := db.Exec(sqlStatement, matchID)
+	err = deleteMatch(tx, matchID)
+	if err == errBracketAdvanced || err == errLadderMoved {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Can't delete the match, " + err.Error()})
+		return
+	} else if handleError(err, c) {
+		return
+	}
 
+	err = tx.Commit()
 	if handleError(err, c) {
 		return
 	}
@@ -425,13 +1239,51 @@ func deleteMatchFromID(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+// Helper function
+//
+// Removes the match and everything recorded in it, taking back its ratings and its place in a draw
+// A ladder challenge played in it goes back to waiting for an answer
+func deleteMatch(q queryer, matchID int) error {
+	err := matchReopened(q, matchID)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement := `with points as (DELETE FROM point WHERE match_id = $1),
+	events as (DELETE FROM point_event WHERE match_id = $1),
+	games as (DELETE FROM game WHERE set_id IN (SELECT id FROM set WHERE match_id = $1)),
+	sets as (DELETE FROM set WHERE match_id = $1),
+	parts as (DELETE FROM match_participant WHERE match_id = $1),
+	res as (DELETE FROM match_result WHERE match_id = $1),
+	slots as (UPDATE bracket_slot SET match_id = NULL WHERE match_id = $1),
+	challenges as (UPDATE challenge SET match_id = NULL, status = 'pending' WHERE match_id = $1)
+	DELETE FROM match WHERE id= $1;`
+
+	_, err = q.Exec(sqlStatement, matchID)
+	return err
+}
+
 // Endpoint /matches/:id
 //
 // Returns a match object from the provided endpoint
 func getMatchFromID(c *gin.Context) {
-	matchID := c.Param("id")
+	param := c.Param("id")
+	matchID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	match, err := getMatch(matchID)
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, match)
+}
 
-	sqlStatement := `SELECT match.id, match.comp_id, comp.comp_name, comp.is_private, start_date, end_date, winner_id 
+// Returns the match with its comp, players, format and score
+func getMatch(matchID int) (Match, error) {
+	sqlStatement := `SELECT match.id, match.comp_id, comp.comp_name, comp.is_private, start_date, round, end_date, winner_id, reason, ` + matchStatusColumn + `, match.version
 		FROM match
 		LEFT JOIN match_result ON match.id = match_result.match_id
 		LEFT JOIN comp ON comp.id = match.comp_id
@@ -440,29 +1292,28 @@ func getMatchFromID(c *gin.Context) {
 	var match Match
 	var comp Competition
 
-	err := db.QueryRow(sqlStatement, matchID).Scan(&match.MatchID, &comp.Id, &comp.Name, &comp.IsPrivate, &match.StartDate,
-		&match.EndDate, &match.WinnerID)
-
-	if handleError(err, c) {
-		return
+	err := db.QueryRow(sqlStatement, matchID).Scan(&match.MatchID, &comp.Id, &comp.Name, &comp.IsPrivate, &match.StartDate, &match.Round,
+		&match.EndDate, &match.WinnerID, &match.EndReason, &match.Status, &match.Version)
+	if err != nil {
+		return match, err
 	}
 
-	match.Player1, match.Player2 = getPlayersFromMatch(match.MatchID)
-
-	score := MatchScore{}
-	score.Player1, score.Player2 = getMatchScore(match.MatchID, match.Player1.Id, match.Player2.Id)
-	match.Score = &score
+	err = getMatchScore(&match)
+	if err != nil {
+		return match, err
+	}
 
 	if comp.Id != nil {
 		match.Competition = &comp
 	}
 
-	c.JSON(http.StatusOK, match)
+	return match, nil
 }
 
 // Endpoint: /matches/:id/stats
 //
-// Get a count of all point stats for each player and stats for each point, game and set
+// Get a count of all point stats for each player and team, and stats for each point, game and set
+// In doubles errors are only counted per team, as a point doesn't record which player made the error
 func getMatchStats(c *gin.Context) {
 	param := c.Param("id")
 	matchID, err := strconv.Atoi(param)
@@ -471,29 +1322,48 @@ func getMatchStats(c *gin.Context) {
 	}
 
 	var response struct {
-		Points  []Point          `json:"points"`
-		Player1 PlayerMatchStats `json:"player1"`
-		Player2 PlayerMatchStats `json:"player2"`
+		Points  []Point            `json:"points"`
+		Sets    []SetScore         `json:"sets"`
+		SetsWon MatchScore         `json:"setsWon"`
+		Player1 PlayerMatchStats   `json:"player1"`
+		Player2 PlayerMatchStats   `json:"player2"`
+		Players []PlayerMatchStats `json:"players"`
+		Team1   TeamMatchStats     `json:"team1"`
+		Team2   TeamMatchStats     `json:"team2"`
+		Rallies RallyStats         `json:"rallies"`
+	}
+
+	state, teams, err := getMatchState(db, matchID)
+	if handleError(err, c) {
+		return
 	}
 
-	// Get player stats
-	p1, p2 := getPlayersFromMatch(matchID)
+	// Get games and tiebreak points for each set
+	response.Sets, response.SetsWon = getSetScores(state, teams)
+
+	// Get the serve and return stat sheets
+	sideSheets, playerSheets, err := getStatSheets(db, matchID)
+	if handleError(err, c) {
+		return
+	}
+	response.Team1.Sheet, response.Team2.Sheet = sideSheets[0], sideSheets[1]
 
+	// Get serving stats for each player
 	sqlStatement := `SELECT SUM(p.faults) as faults,
 	Count(CASE WHEN p.faults>1 THEN 1 END ) as double_faults, 
 	SUM(p.lets) as lets, 
 	Count(CASE WHEN p.ace THEN 1 END) as aces,
 	p.server_id 
 	FROM point p
-	LEFT JOIN match ON match.id = p.match_id
-	where match.id = $1 AND (p.server_id = $2 OR p.server_id = $3)
+	where p.match_id = $1 AND p.winner_id IS NOT NULL
 	GROUP BY p.server_id`
 
-	rows, err := db.Query(sqlStatement, matchID, p1.Id, p2.Id)
+	rows, err := db.Query(sqlStatement, matchID)
 	if handleError(err, c) {
 		return
 	}
 
+	serving := map[int]PlayerMatchStats{}
 	for rows.Next() {
 		var pstats PlayerMatchStats
 		var id int
@@ -501,99 +1371,110 @@ func getMatchStats(c *gin.Context) {
 		if err != nil {
 			println(err.Error())
 		}
-		if p1.Id == id {
-			pstats.Player = p1
-			response.Player1 = pstats
-		} else {
-			pstats.Player = p2
-			response.Player2 = pstats
-		}
-	}
-
-	// Count errors
-	sqlStatement = `SELECT Count(CASE WHEN point.unforced_error THEN 1 END), player.id from player
-	LEFT JOIN match_participant mp on mp.player_id = id
-	LEFT JOIN point ON point.match_id = mp.match_id
-	WHERE point.winner_id != player.id and mp.match_id = $1
-	GROUP BY player.id;`
-
-	rows, err = db.Query(sqlStatement, matchID)
-	if handleError(err, c) {
-		return
-	}
-
-	for rows.Next() {
-		var errors, id int
-		err = rows.Scan(&errors, &id)
-		if err != nil {
-			println(err.Error())
-		}
-		if p1.Id == id {
-			response.Player1.Errors = errors
-		} else {
-			response.Player2.Errors = errors
-		}
+		serving[id] = pstats
 	}
 
 	// Get all points from match
-	sqlStatement = `SELECT number, winner_id, server_id, receiver_id, 
+	// The set and game of each point come from replaying the match, as points scored before games were stored have none
+	sqlStatement = `SELECT point.number, point.winner_id, point.server_id, point.receiver_id, 
 	faults, 
 	CASE WHEN faults > 1 THEN TRUE 
-	ELSE FALSE END double_fault, lets, ace, unforced_error 
+	ELSE FALSE END double_fault, lets, ace, unforced_error,
+	serve_number, serve_direction, ending, shot_type, rally_length, striker_id
 	FROM point
-	WHERE match_id = $1;`
+	WHERE point.match_id = $1 AND point.winner_id IS NOT NULL
+	ORDER BY point.number;`
 
 	rows, err = db.Query(sqlStatement, matchID)
 	if handleError(err, c) {
 		return
 	}
 
+	teamStats := [2]*TeamMatchStats{&response.Team1, &response.Team2}
+	serves := map[int]*ServeStats{}
+	shots := map[int]*ShotStats{}
+	for _, team := range teams {
+		for _, player := range team {
+			serves[player.Id], shots[player.Id] = &ServeStats{}, &ShotStats{}
+		}
+	}
+
+	replay := scoring.NewMatch(state.Format, state.FirstServer())
 	for rows.Next() {
 		var point Point
-		err = rows.Scan(&point.Number, &point.WinnerID, &point.ServerID, &point.ReceiverID, &point.Stats.Faults, &point.Stats.DoubleFault, &point.Stats.Lets, &point.Stats.Ace, &point.Stats.Error)
+		err = rows.Scan(&point.Number, &point.WinnerID, &point.ServerID, &point.ReceiverID, &point.Stats.Faults, &point.Stats.DoubleFault, &point.Stats.Lets, &point.Stats.Ace, &point.Stats.Error,
+			&point.Stats.ServeNumber, &point.Stats.ServeDirection, &point.Stats.Ending, &point.Stats.ShotType, &point.Stats.RallyLength, &point.Stats.StrikerID)
 		if err != nil {
 			println(err.Error())
 		}
-		response.Points = append(response.Points, point)
-	}
-
-	c.JSON(http.StatusOK, response)
 
-}
+		winner := teams.side(point.WinnerID)
+		set := replay.Sets[len(replay.Sets)-1]
+		point.Set, point.Game, point.Tiebreak = len(replay.Sets), set.Games[0]+set.Games[1]+1, replay.Tiebreak
+		if _, err = replay.Apply(winner); err != nil {
+			println(err.Error())
+		}
+		response.Points = append(response.Points, point)
 
-// Endpoint: /matches/:id/latest
-//
-// Delete the latest point and return the one before
-func deleteLatestPoint(c *gin.Context) {
-	matchID := c.Param("id")
+		teamStats[winner].PointsWon++
+		if point.Stats.Error {
+			teamStats[1-winner].Errors++
+		}
 
-	sqlStatement := `WITH latest AS (SELECT number FROM point
-		WHERE match_id = $1
-		ORDER BY number DESC
-		LIMIT 1)
-		DELETE FROM point WHERE match_id = $1 and number = (select number from latest)`
+		// Serve stats go to the server, shot stats to whoever hit the last shot if it is known
+		if serverSide := teams.side(point.ServerID); serverSide >= 0 {
+			addServeStats(&teamStats[serverSide].Serve, point, serverSide == winner)
+			addServeStats(serves[point.ServerID], point, serverSide == winner)
+		}
 
-	_, err := db.Exec(sqlStatement, matchID)
-	if handleError(err, c) {
-		return
+		if point.Stats.StrikerID != nil {
+			if striker := teams.side(*point.Stats.StrikerID); striker >= 0 {
+				addShotStats(&teamStats[striker].Shots, point)
+				addShotStats(shots[*point.Stats.StrikerID], point)
+			}
+		} else if point.Stats.Ending != nil {
+			striker := 1 - winner
+			if *point.Stats.Ending == EndingWinner {
+				striker = winner
+			}
+			addShotStats(&teamStats[striker].Shots, point)
+		}
+		addRallyStats(&response.Rallies, point)
 	}
 
-	sqlStatement = `SELECT number, faults, lets, ace, unforced_error, winner_id, server_id, receiver_id FROM point
-	WHERE match_id = $1
-	ORDER BY number DESC
-	LIMIT 1`
+	// Add each player's serving stats to their team
+	for side, team := range teams {
+		teamStats[side].Players = team
 
-	var response Point
+		for i := range team {
+			pstats := serving[team[i].Id]
+			pstats.Player = &team[i]
+			pstats.Serve, pstats.Shots = *serves[team[i].Id], *shots[team[i].Id]
+			pstats.Sheet = *playerSheets[team[i].Id]
+			if len(team) == 1 {
+				pstats.Errors = teamStats[side].Errors
+			}
 
-	err = db.QueryRow(sqlStatement, matchID).Scan(&response.Number, &response.Stats.Faults, &response.Stats.Lets, &response.Stats.Ace, &response.Stats.Error, &response.WinnerID, &response.ServerID, &response.ReceiverID)
-	if handleError(err, c) {
-		return
+			teamStats[side].Faults += pstats.Faults
+			teamStats[side].DoubleFaults += pstats.DoubleFaults
+			teamStats[side].Lets += pstats.Lets
+			teamStats[side].Aces += pstats.Aces
+			response.Players = append(response.Players, pstats)
+		}
 	}
+	response.Player1, response.Player2 = response.Players[0], response.Players[len(teams[0])]
 
 	c.JSON(http.StatusOK, response)
 
 }
 
+// Endpoint: /matches/:id/latest
+//
+// Undo the latest point, the same as /matches/:id/undo with a count of 1
+func deleteLatestPoint(c *gin.Context) {
+	stepPointHistory(c, ActionUndo)
+}
+
 // Endpoint: /matches/:id/latest
 //
 // Return the latest point to score
@@ -618,27 +1499,29 @@ func getMatchLatestPoint(c *gin.Context) {
 
 // Endpoint: /comps/:id/matches
 //
-// Return all matches within the comp
+// Return all matches within the comp, optionally only those with the given status
 func getCompMatches(c *gin.Context) {
 	compID := c.Param("id")
 
 	var request struct {
-		Limit *int       `form:"limit"`
-		From  *time.Time `form:"from"`
-		To    *time.Time `form:"to"`
+		Limit  *int       `form:"limit"`
+		From   *time.Time `form:"from"`
+		To     *time.Time `form:"to"`
+		Status *string    `form:"status"`
 	}
 
 	if !tryGetRequest(c, &request) {
 		return
 	}
 
-	sqlStatement := `SELECT id, start_date, end_date, winner_id FROM match
+	sqlStatement := `SELECT id, start_date, round, end_date, winner_id, reason, ` + matchStatusColumn + ` AS match_status FROM match
 	LEFT JOIN match_result ON match.id = match_result.match_id
 	WHERE match.comp_id = $1 and (start_date >= $2 or $2 is NULL) and (end_date >= $3 or $3 is NULL)
+	and (` + matchStatusColumn + ` = $5 or $5 is NULL)
 	ORDER BY end_date DESC
 	LIMIT $4`
 
-	rows, err := db.Query(sqlStatement, compID, request.From, request.To, request.Limit)
+	rows, err := db.Query(sqlStatement, compID, request.From, request.To, request.Limit, request.Status)
 
 	if handleError(err, c) {
 		return
@@ -650,16 +1533,15 @@ func getCompMatches(c *gin.Context) {
 	matchResponse.Matches = []Match{}
 	for rows.Next() {
 		var match Match
-		err = rows.Scan(&match.MatchID, &match.StartDate, &match.EndDate, &match.WinnerID)
+		err = rows.Scan(&match.MatchID, &match.StartDate, &match.Round, &match.EndDate, &match.WinnerID, &match.EndReason, &match.Status)
 		if err != nil {
 			println(err.Error())
 		}
 
-		match.Player1, match.Player2 = getPlayersFromMatch(match.MatchID)
-
-		score := MatchScore{}
-		score.Player1, score.Player2 = getMatchScore(match.MatchID, match.Player1.Id, match.Player2.Id)
-		match.Score = &score
+		err = getMatchScore(&match)
+		if err != nil {
+			println(err.Error())
+		}
 
 		matchResponse.Matches = append(matchResponse.Matches, match)
 	}
@@ -667,67 +1549,73 @@ func getCompMatches(c *gin.Context) {
 	c.JSON(http.StatusOK, matchResponse)
 }
 
-func getMatchScore(matchID, p1Id, p2Id int) (int, int) {
+// Helper function
+//
+// Replays the points of the match to fill in its teams, format, points won and set scores
+func getMatchScore(match *Match) error {
+	state, teams, err := getMatchState(db, match.MatchID)
+	if err != nil {
+		return err
+	}
 
-	// Get players wins
-	sqlStatement := `
-	SELECT player.id, (SELECT COUNT(winner_id) FROM point
-			WHERE winner_id = player.id and match_id = $1) as wins
-	FROM player
-	JOIN match_participant mp ON player_id = player.id
-	WHERE mp.match_id = $1;`
-	rows, err := db.Query(sqlStatement, matchID)
+	match.Format, err = getMatchFormat(db, match.MatchID)
 	if err != nil {
-		println(err.Error())
-		return 0, 0
+		return err
 	}
 
-	var p1wins, p2wins int
-	for rows.Next() {
-		var wins, id int
-		err = rows.Scan(&id, &wins)
-		if err != nil {
-			println(err.Error())
-		}
+	setMatchTeams(match, teams)
+	match.Score = &MatchScore{Player1: state.PointsWon[0], Player2: state.PointsWon[1]}
 
-		if id == p1Id {
-			p1wins = wins
-		} else {
-			p2wins = wins
-		}
-	}
+	sets, setsWon := getSetScores(state, teams)
+	match.Sets = sets
+	match.SetsWon = &setsWon
 
-	return p1wins, p2wins
+	return nil
 }
 
-// Returns 2 pointers to each player in the specified match
-func getPlayersFromMatch(matchID int) (*Player, *Player) {
-	var player1, player2 *Player
+// Fills in the teams of the match, player 1 and player 2 are the first player of each team
+func setMatchTeams(match *Match, teams matchTeams) {
+	match.Team1, match.Team2 = teams[0], teams[1]
+	match.Player1, match.Player2 = teams.lead(0), teams.lead(1)
+	match.Doubles = len(teams[0]) > 1
+}
+
+// Returns the players on each team in the specified match, in serving order
+//
+// Matches recorded before teams were stored have one player on each team
+func getMatchTeams(q queryer, matchID int) (matchTeams, error) {
+	var teams matchTeams
 
-	pStatement := `SELECT id, first_name, last_name, is_admin FROM player 
-	LEFT JOIN match_participant mp ON mp.player_id = player.id
-	WHERE match_id = $1`
-	prows, perr := db.Query(pStatement, matchID)
-	if perr != nil {
-		println(perr.Error())
+	sqlStatement := `SELECT id, first_name, last_name, is_admin, mp.team FROM player 
+	JOIN match_participant mp ON mp.player_id = player.id
+	WHERE match_id = $1
+	ORDER BY mp.team, mp.serve_order, player.id`
+	rows, err := q.Query(sqlStatement, matchID)
+	if err != nil {
+		return teams, err
 	}
+	defer rows.Close()
 
-	p := true
-	for prows.Next() {
-		var scannedPlayer Player
-		perr = prows.Scan(&scannedPlayer.Id, &scannedPlayer.FirstName, &scannedPlayer.LastName, &scannedPlayer.Admin)
-		if perr != nil {
-			println(perr.Error())
+	for i := 0; rows.Next(); i++ {
+		var player Player
+		var team *int
+		err = rows.Scan(&player.Id, &player.FirstName, &player.LastName, &player.Admin, &team)
+		if err != nil {
+			return teams, err
 		}
 
-		if p {
-			player1 = &scannedPlayer
-		} else {
-			player2 = &scannedPlayer
+		side := i % 2
+		if team != nil {
+			side = *team - 1
 		}
-		p = !p
+		teams[side] = append(teams[side], player)
+	}
+
+	if len(teams[0]) == 0 || len(teams[1]) == 0 {
+		return teams, sql.ErrNoRows
 	}
-	return player1, player2
+
+	return teams, nil
 }
 
 // Endpoint: /comps/:id
@@ -737,9 +1625,9 @@ func getCompWithID(c *gin.Context) {
 	id := c.Param("id")
 
 	var comp Competition
-	sqlStatement := `SELECT id, comp_name, is_private FROM comp where id=$1;`
+	sqlStatement := `SELECT id, comp_name, is_private, comp_type FROM comp where id=$1;`
 
-	err := db.QueryRow(sqlStatement, id).Scan(&comp.Id, &comp.Name, &comp.IsPrivate)
+	err := db.QueryRow(sqlStatement, id).Scan(&comp.Id, &comp.Name, &comp.IsPrivate, &comp.Type)
 	if handleError(err, c) {
 		return
 	}
@@ -749,61 +1637,25 @@ func getCompWithID(c *gin.Context) {
 
 // Endpoint: /comps/:id/table
 //
-// Return an array of table rows containing data about each competitor
+// Return an array of table rows containing data about each registered player, including those yet to play
+// Retirements, walkovers and defaults count as a win and a loss, abandoned matches are not counted
+// Rows are ordered by the points and tiebreakers set in the comp's table rules
 func getCompTable(c *gin.Context) {
-	id := c.Param("id")
-
-	type Competitor struct {
-		Player Player `json:"player"`
-		Played int    `json:"played"`
-		Wins   int    `json:"wins"`
-		Losses int    `json:"losses"`
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
 	}
 
 	var response struct {
 		Competitors []Competitor `json:"competitors"`
 	}
 
-	sqlStatement := `SELECT 
-	p.id, first_name, last_name,
-	(SELECT count(player_id)
-	FROM match_participant
-	JOIN match ON match_id = match.id
-	JOIN comp on match.comp_id = comp.id
-	JOIN match_result mr ON mr.match_id =match.id
-	where comp.id = $1 and player_id = p.id) AS played,  
-	(SELECT count(winner_id)
-	FROM match_result
-	JOIN match ON match_id = match.id
-	JOIN comp on match.comp_id = comp.id
-	where comp.id = $1 and winner_id = p.id) AS wins  
-	FROM player p
-		JOIN match_participant mp on mp.player_id = p.id
-		JOIN match m ON mp.match_id = m.id
-	JOIN comp c ON c.id = m.comp_id
-		JOIN match_result mr on mr.match_id = m.id 
-	WHERE c.id = $1
-	GROUP BY p.id
-	ORDER BY  wins DESC
-	`
-
-	rows, err := db.Query(sqlStatement, id)
+	response.Competitors, err = getCompStandings(db, compID)
 	if handleError(err, c) {
 		return
 	}
 
-	response.Competitors = []Competitor{}
-	for rows.Next() {
-		var competitor Competitor
-		err = rows.Scan(&competitor.Player.Id, &competitor.Player.FirstName, &competitor.Player.LastName, &competitor.Played, &competitor.Wins)
-		if err != nil {
-			println(err.Error())
-		}
-
-		competitor.Losses = competitor.Played - competitor.Wins
-		response.Competitors = append(response.Competitors, competitor)
-	}
-
 	c.JSON(http.StatusOK, response)
 }
 
@@ -850,49 +1702,20 @@ func getPlayerComps(c *gin.Context) {
 		return
 	}
 
-	// Getting player position
-
-	sqlStatement = `SELECT 
-	p.id,
-	(SELECT count(winner_id)
-	FROM match_result
-	JOIN match ON match_id = match.id
-	JOIN comp on match.comp_id = comp.id
-	where comp.id = $1 and winner_id = p.id) AS wins  
-	FROM player p
-	JOIN match_participant mp on mp.player_id = p.id
-	JOIN match m ON mp.match_id = m.id
-	JOIN comp c ON c.id = m.comp_id
-	JOIN match_result mr on mr.match_id = m.id 
-	WHERE c.id = $1
-	GROUP BY p.id
-	ORDER BY wins DESC
-	;`
-
-	// For each comp
+	// Getting player position from the same table the standings endpoint returns
 
 	for index := 0; index < len(res.Competitions); index++ {
-		rows, err := db.Query(sqlStatement, res.Competitions[index].Id)
+		standings, err := getCompStandings(db, *res.Competitions[index].Id)
 		if handleError(err, c) {
 			return
 		}
 
-		// For each player in comp
-		i := 1
-		for rows.Next() {
-			var scannedID, wins int
-			err = rows.Scan(&scannedID, &wins)
-			if err != nil {
-				println(err.Error())
-			}
-
-			if scannedID == playerid {
-				res.Competitions[index].PlayerPos = &i
+		for i, competitor := range standings {
+			if competitor.Player.Id == playerid {
+				pos := i + 1
+				res.Competitions[index].PlayerPos = &pos
 				break
 			}
-
-			i++
-
 		}
 	}
 
@@ -0,0 +1,112 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scoring
+
+import "fmt"
+
+// Format describes how points, games and sets are won in a match
+type Format struct {
+	BestOf              int  `json:"bestOf"`
+	SetGames            int  `json:"setGames"`
+	TiebreakAt          *int `json:"tiebreakAt"`
+	TiebreakPoints      int  `json:"tiebreakPoints"`
+	SuddenDeathTiebreak bool `json:"suddenDeathTiebreak"`
+	NoAd                bool `json:"noAd"`
+	MatchTiebreak       bool `json:"matchTiebreak"`
+	MatchTiebreakPoints int  `json:"matchTiebreakPoints"`
+}
+
+// Returns the format with the given preset name
+//
+// standard: best of 3 tiebreak sets, bestOfFive: best of 5 tiebreak sets,
+// matchTiebreak: best of 3 with a 10 point match tiebreak in lieu of the final set,
+// noAd: standard with no-ad games, fast4: first to 4 games with no-ad games and a sudden death tiebreak at 3-3,
+// shortSets: first to 4 games with a tiebreak at 4-4, proSet: a single set to 8 games with a tiebreak at 8-8
+func Preset(name string) (Format, bool) {
+	six, three, four, eight := 6, 3, 4, 8
+	format := Format{BestOf: 3, SetGames: 6, TiebreakAt: &six, TiebreakPoints: 7, MatchTiebreakPoints: 10}
+
+	switch name {
+	case "standard":
+	case "bestOfFive":
+		format.BestOf = 5
+	case "matchTiebreak":
+		format.MatchTiebreak = true
+	case "noAd":
+		format.NoAd = true
+	case "fast4":
+		format.SetGames = 4
+		format.TiebreakAt = &three
+		format.TiebreakPoints = 5
+		format.SuddenDeathTiebreak = true
+		format.NoAd = true
+	case "shortSets":
+		format.SetGames = 4
+		format.TiebreakAt = &four
+	case "proSet":
+		format.BestOf = 1
+		format.SetGames = 8
+		format.TiebreakAt = &eight
+	default:
+		return format, false
+	}
+
+	return format, true
+}
+
+// Returns an error describing why the format can't be played, nil if it is valid
+func (f Format) Validate() error {
+	if f.BestOf < 1 || f.BestOf%2 == 0 {
+		return fmt.Errorf("matches must be best of an odd number of sets")
+	}
+	if f.SetGames < 1 {
+		return fmt.Errorf("sets must be played to at least 1 game")
+	}
+	if f.TiebreakAt != nil && (*f.TiebreakAt < f.SetGames-1 || *f.TiebreakAt > f.SetGames) {
+		return fmt.Errorf("tiebreaks must be played at %d or %d games all", f.SetGames-1, f.SetGames)
+	}
+	if f.TiebreakPoints < 1 || f.MatchTiebreakPoints < 1 {
+		return fmt.Errorf("tiebreaks must be played to at least 1 point")
+	}
+	if f.MatchTiebreak && f.BestOf == 1 {
+		return fmt.Errorf("a match tiebreak can only replace the final set of a best of 3 or 5 match")
+	}
+	return nil
+}
+
+// A game is won by the first side to four points, with a two point lead
+// With no-ad scoring the point played at deuce decides the game
+func (f Format) gameWon(points, otherPoints int) bool {
+	return points >= 4 && (points-otherPoints >= 2 || f.NoAd)
+}
+
+// A tiebreak is won by the first side to the target number of points, with a two point lead
+// A match tiebreak is never sudden death
+func (f Format) tiebreakWon(points, otherPoints int, matchTiebreak bool) bool {
+	if matchTiebreak {
+		return points >= f.MatchTiebreakPoints && points-otherPoints >= 2
+	}
+	return points >= f.TiebreakPoints && (points-otherPoints >= 2 || f.SuddenDeathTiebreak)
+}
+
+// A set is won by the first side to the format's number of games, with a two game lead
+func (f Format) setWon(games, otherGames int) bool {
+	return games >= f.SetGames && games-otherGames >= 2
+}
+
+// A tiebreak is played once both sides reach the format's tiebreak score, e.g. six games all
+func (f Format) tiebreakDue(games, otherGames int) bool {
+	return f.TiebreakAt != nil && games == *f.TiebreakAt && otherGames == *f.TiebreakAt
+}
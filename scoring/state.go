@@ -0,0 +1,245 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scoring computes the score of a tennis match from the points played, without a database.
+//
+// The two sides of a match are numbered 0 and 1.
+package scoring
+
+import (
+	"errors"
+	"strconv"
+)
+
+// NoWinner is the winner of a set or match that is still being played
+const NoWinner = -1
+
+var (
+	ErrInvalidSide   = errors.New("scoring: side must be 0 or 1")
+	ErrMatchComplete = errors.New("scoring: match is complete")
+	ErrNoPoints      = errors.New("scoring: no points to undo")
+)
+
+// Set is the games won by each side in a set, along with the tiebreak points if one was played
+type Set struct {
+	Games    [2]int
+	Tiebreak *[2]int
+	Winner   int
+}
+
+// Result describes what a point completed
+type Result struct {
+	GameWon  bool
+	SetWon   bool
+	MatchWon bool
+}
+
+// MatchState is the score of a match after a sequence of points
+type MatchState struct {
+	Format Format
+
+	// Sets played so far, the last one is in progress until the match is won
+	Sets []Set
+	// Points won by each side in the current game or tiebreak
+	Game [2]int
+	// True when the current game is a tiebreak
+	Tiebreak bool
+	// Total points won by each side in the match
+	PointsWon [2]int
+	Winner    int
+
+	firstServer int
+	serveTurn   int
+	points      []int
+}
+
+// Returns the state of a match that has not had a point played
+func NewMatch(format Format, firstServer int) *MatchState {
+	return &MatchState{
+		Format:      format,
+		Sets:        []Set{{Winner: NoWinner}},
+		Winner:      NoWinner,
+		firstServer: firstServer,
+	}
+}
+
+// Returns the state of a match after each of the winners have won a point in turn
+func Replay(format Format, firstServer int, winners []int) (*MatchState, error) {
+	m := NewMatch(format, firstServer)
+	for _, winner := range winners {
+		if _, err := m.Apply(winner); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Apply awards the next point to the winning side and returns what the point completed
+func (m *MatchState) Apply(winner int) (Result, error) {
+	var result Result
+
+	if winner != 0 && winner != 1 {
+		return result, ErrInvalidSide
+	}
+	if m.Complete() {
+		return result, ErrMatchComplete
+	}
+
+	loser := 1 - winner
+	m.points = append(m.points, winner)
+	m.PointsWon[winner]++
+	m.Game[winner]++
+
+	if m.Tiebreak {
+		if !m.Format.tiebreakWon(m.Game[winner], m.Game[loser], m.MatchTiebreak()) {
+			return result, nil
+		}
+	} else if !m.Format.gameWon(m.Game[winner], m.Game[loser]) {
+		return result, nil
+	}
+
+	result.GameWon = true
+	set := &m.Sets[len(m.Sets)-1]
+	set.Games[winner]++
+
+	wasTiebreak := m.Tiebreak
+	if wasTiebreak {
+		tiebreak := m.Game
+		set.Tiebreak = &tiebreak
+	}
+
+	// A tiebreak counts as a single service turn, so the side that received first serves the next game
+	m.serveTurn++
+	m.Game = [2]int{}
+	m.Tiebreak = false
+
+	if !wasTiebreak && !m.Format.setWon(set.Games[winner], set.Games[loser]) {
+		m.Tiebreak = m.Format.tiebreakDue(set.Games[winner], set.Games[loser])
+		return result, nil
+	}
+
+	result.SetWon = true
+	set.Winner = winner
+
+	if m.SetsWon()[winner] > m.Format.BestOf/2 {
+		result.MatchWon = true
+		m.Winner = winner
+		return result, nil
+	}
+
+	m.Sets = append(m.Sets, Set{Winner: NoWinner})
+	m.Tiebreak = m.MatchTiebreak()
+
+	return result, nil
+}
+
+// Undo removes the last point played, reopening the game, set or match it completed
+func (m *MatchState) Undo() error {
+	if len(m.points) == 0 {
+		return ErrNoPoints
+	}
+
+	previous, err := Replay(m.Format, m.firstServer, m.points[:len(m.points)-1])
+	if err != nil {
+		return err
+	}
+	*m = *previous
+	return nil
+}
+
+// Returns the winner of every point played, in order
+func (m *MatchState) Points() []int {
+	return append([]int{}, m.points...)
+}
+
+// Returns the number of the next point to be played, starting at 1
+func (m *MatchState) PointNumber() int {
+	return len(m.points) + 1
+}
+
+// Returns true once a side has won the match
+func (m *MatchState) Complete() bool {
+	return m.Winner != NoWinner
+}
+
+// Returns true when the current game is a match tiebreak played in lieu of the final set
+func (m *MatchState) MatchTiebreak() bool {
+	return m.Format.MatchTiebreak && len(m.Sets) == m.Format.BestOf && m.Sets[len(m.Sets)-1].Games == [2]int{}
+}
+
+// Returns the number of sets won by each side
+func (m *MatchState) SetsWon() [2]int {
+	var won [2]int
+	for _, set := range m.Sets {
+		if set.Winner != NoWinner {
+			won[set.Winner]++
+		}
+	}
+	return won
+}
+
+// Returns the number of service turns taken before the next point
+//
+// Service passes to the other side every game, and in a tiebreak after the first point then every two points.
+// Doubles rotate service through all four players, so the turn identifies the player as well as the side.
+func (m *MatchState) ServeTurn() int {
+	turn := m.serveTurn
+	if m.Tiebreak {
+		turn += (m.Game[0] + m.Game[1] + 1) / 2
+	}
+	return turn
+}
+
+// Returns the side serving the next point
+func (m *MatchState) Server() int {
+	return (m.firstServer + m.ServeTurn()) % 2
+}
+
+// Returns the side that served the first point of the match
+func (m *MatchState) FirstServer() int {
+	return m.firstServer
+}
+
+// Returns true if the side would win the current game or tiebreak by winning the next point
+func (m *MatchState) GamePoint(side int) bool {
+	if m.Complete() {
+		return false
+	}
+	points, otherPoints := m.Game[side]+1, m.Game[1-side]
+	if m.Tiebreak {
+		return m.Format.tiebreakWon(points, otherPoints, m.MatchTiebreak())
+	}
+	return m.Format.gameWon(points, otherPoints)
+}
+
+// Returns true if the next point is a break point, a game point for the receiving side outside a tiebreak
+func (m *MatchState) BreakPoint() bool {
+	return !m.Tiebreak && m.GamePoint(1-m.Server())
+}
+
+// Returns the umpire's call for a side's points in the current game, e.g. 15, 40 or AD
+// Tiebreak points are called as a number
+func (m *MatchState) GameCall(side int) string {
+	points, otherPoints := m.Game[side], m.Game[1-side]
+	if m.Tiebreak {
+		return strconv.Itoa(points)
+	}
+	if points >= 3 && otherPoints >= 3 {
+		if points > otherPoints {
+			return "AD"
+		}
+		return "40"
+	}
+	return []string{"0", "15", "30", "40"}[points]
+}
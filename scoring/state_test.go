@@ -0,0 +1,283 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scoring
+
+import "testing"
+
+// Builds a sequence of point winners
+type points []int
+
+// The side wins n points in a row
+func (p points) won(side, n int) points {
+	for i := 0; i < n; i++ {
+		p = append(p, side)
+	}
+	return p
+}
+
+// The side wins n games to love in a row
+func (p points) games(side, n int) points {
+	return p.won(side, 4*n)
+}
+
+// The sides share games until they reach n games all, side 0 winning first
+func (p points) gamesAll(n int) points {
+	for i := 0; i < n; i++ {
+		p = p.games(0, 1).games(1, 1)
+	}
+	return p
+}
+
+func preset(t *testing.T, name string) Format {
+	format, ok := Preset(name)
+	if !ok {
+		t.Fatalf("no preset %s", name)
+	}
+	return format
+}
+
+func replay(t *testing.T, format Format, winners points) *MatchState {
+	m, err := Replay(format, 0, winners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestGameScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		points   points
+		calls    [2]string
+		gamesWon [2]int
+	}{
+		{"love all", "standard", points{}, [2]string{"0", "0"}, [2]int{0, 0}},
+		{"thirty fifteen", "standard", points{}.won(0, 2).won(1, 1), [2]string{"30", "15"}, [2]int{0, 0}},
+		{"deuce", "standard", points{}.won(0, 3).won(1, 3), [2]string{"40", "40"}, [2]int{0, 0}},
+		{"advantage", "standard", points{}.won(0, 3).won(1, 3).won(1, 1), [2]string{"40", "AD"}, [2]int{0, 0}},
+		{"back to deuce", "standard", points{}.won(0, 3).won(1, 3).won(1, 1).won(0, 1), [2]string{"40", "40"}, [2]int{0, 0}},
+		{"advantage game", "standard", points{}.won(0, 3).won(1, 3).won(1, 2), [2]string{"0", "0"}, [2]int{0, 1}},
+		{"game to love", "standard", points{}.games(0, 1), [2]string{"0", "0"}, [2]int{1, 0}},
+		{"no-ad deciding point", "noAd", points{}.won(0, 3).won(1, 3).won(1, 1), [2]string{"0", "0"}, [2]int{0, 1}},
+		{"fast4 deciding point", "fast4", points{}.won(0, 3).won(1, 3).won(0, 1), [2]string{"0", "0"}, [2]int{1, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := replay(t, preset(t, test.format), test.points)
+			calls := [2]string{m.GameCall(0), m.GameCall(1)}
+			if calls != test.calls {
+				t.Errorf("calls %v, want %v", calls, test.calls)
+			}
+			if games := m.Sets[0].Games; games != test.gamesWon {
+				t.Errorf("games %v, want %v", games, test.gamesWon)
+			}
+		})
+	}
+}
+
+func TestSetsAndMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		points   points
+		sets     [][2]int
+		tiebreak bool
+		matchTB  bool
+		winner   int
+	}{
+		{"six love", "standard", points{}.games(0, 6), [][2]int{{6, 0}, {0, 0}}, false, false, NoWinner},
+		{"five all plays on", "standard", points{}.gamesAll(5).games(0, 1), [][2]int{{6, 5}}, false, false, NoWinner},
+		{"seven five", "standard", points{}.gamesAll(5).games(0, 2), [][2]int{{7, 5}, {0, 0}}, false, false, NoWinner},
+		{"tiebreak at six all", "standard", points{}.gamesAll(6), [][2]int{{6, 6}}, true, false, NoWinner},
+		{"tiebreak needs two clear", "standard", points{}.gamesAll(6).won(0, 6).won(1, 6).won(0, 1), [][2]int{{6, 6}}, true, false, NoWinner},
+		{"tiebreak won", "standard", points{}.gamesAll(6).won(0, 6).won(1, 6).won(0, 2), [][2]int{{7, 6}, {0, 0}}, false, false, NoWinner},
+		{"straight sets", "standard", points{}.games(1, 12), [][2]int{{0, 6}, {0, 6}}, false, false, 1},
+		{"best of five", "bestOfFive", points{}.games(1, 12).games(0, 18), [][2]int{{0, 6}, {0, 6}, {6, 0}, {6, 0}, {6, 0}}, false, false, 0},
+		{"match tiebreak due", "matchTiebreak", points{}.games(0, 6).games(1, 6), [][2]int{{6, 0}, {0, 6}, {0, 0}}, true, true, NoWinner},
+		{"match tiebreak at ten nine", "matchTiebreak", points{}.games(0, 6).games(1, 6).won(0, 9).won(1, 9).won(0, 1), [][2]int{{6, 0}, {0, 6}, {0, 0}}, true, true, NoWinner},
+		{"match tiebreak won", "matchTiebreak", points{}.games(0, 6).games(1, 6).won(1, 10), [][2]int{{6, 0}, {0, 6}, {0, 1}}, false, false, 1},
+		{"fast4 set", "fast4", points{}.games(0, 4), [][2]int{{4, 0}, {0, 0}}, false, false, NoWinner},
+		{"fast4 tiebreak at three all", "fast4", points{}.gamesAll(3), [][2]int{{3, 3}}, true, false, NoWinner},
+		{"fast4 sudden death tiebreak", "fast4", points{}.gamesAll(3).won(0, 4).won(1, 4).won(1, 1), [][2]int{{3, 4}, {0, 0}}, false, false, NoWinner},
+		{"pro set eight six", "proSet", points{}.gamesAll(6).games(0, 2), [][2]int{{8, 6}}, false, false, 0},
+		{"pro set eight seven plays on", "proSet", points{}.gamesAll(7).games(0, 1), [][2]int{{8, 7}}, false, false, NoWinner},
+		{"pro set tiebreak at eight all", "proSet", points{}.gamesAll(8), [][2]int{{8, 8}}, true, false, NoWinner},
+		{"pro set tiebreak won", "proSet", points{}.gamesAll(8).won(1, 7), [][2]int{{8, 9}}, false, false, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := replay(t, preset(t, test.format), test.points)
+			if len(m.Sets) != len(test.sets) {
+				t.Fatalf("%d sets, want %d", len(m.Sets), len(test.sets))
+			}
+			for i, set := range m.Sets {
+				if set.Games != test.sets[i] {
+					t.Errorf("set %d is %v, want %v", i+1, set.Games, test.sets[i])
+				}
+			}
+			if m.Tiebreak != test.tiebreak {
+				t.Errorf("tiebreak %v, want %v", m.Tiebreak, test.tiebreak)
+			}
+			if m.MatchTiebreak() != test.matchTB {
+				t.Errorf("match tiebreak %v, want %v", m.MatchTiebreak(), test.matchTB)
+			}
+			if m.Winner != test.winner {
+				t.Errorf("winner %d, want %d", m.Winner, test.winner)
+			}
+			if m.Complete() != (test.winner != NoWinner) {
+				t.Errorf("complete %v", m.Complete())
+			}
+		})
+	}
+}
+
+func TestTiebreakScores(t *testing.T) {
+	m := replay(t, preset(t, "standard"), points{}.gamesAll(6).won(0, 5).won(1, 7))
+	if tiebreak := m.Sets[0].Tiebreak; tiebreak == nil || *tiebreak != [2]int{5, 7} {
+		t.Errorf("tiebreak score %v, want [5 7]", tiebreak)
+	}
+	if m.Sets[0].Winner != 1 {
+		t.Errorf("set winner %d, want 1", m.Sets[0].Winner)
+	}
+}
+
+func TestServeRotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		points points
+		// Server of each of the following points, which are all won by side 0
+		servers []int
+	}{
+		{"first game", "standard", points{}, []int{0, 0, 0, 0, 1}},
+		{"second game", "standard", points{}.games(0, 1), []int{1, 1, 1, 1, 0}},
+		// 12 games have been played so side 0 serves first in the tiebreak, then every two points
+		{"set tiebreak", "standard", points{}.gamesAll(6), []int{0, 1, 1, 0, 0, 1, 1, 1}},
+		// The side that received first in the tiebreak serves the next set
+		{"after tiebreak", "standard", points{}.gamesAll(6).won(1, 7), []int{1, 1, 1, 1, 0}},
+		{"match tiebreak", "matchTiebreak", points{}.games(0, 6).games(1, 6), []int{0, 1, 1, 0, 0}},
+		{"fast4 tiebreak", "fast4", points{}.gamesAll(3), []int{0, 1, 1, 0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := replay(t, preset(t, test.format), test.points)
+			for i, want := range test.servers {
+				if server := m.Server(); server != want {
+					t.Fatalf("point %d served by %d, want %d", i+1, server, want)
+				}
+				if _, err := m.Apply(0); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakAndGamePoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		points    points
+		gamePoint [2]bool
+		breakPt   bool
+	}{
+		{"love all", points{}, [2]bool{false, false}, false},
+		{"forty love", points{}.won(0, 3), [2]bool{true, false}, false},
+		{"love forty", points{}.won(1, 3), [2]bool{false, true}, true},
+		{"deuce", points{}.won(0, 3).won(1, 3), [2]bool{false, false}, false},
+		{"advantage receiver", points{}.won(0, 3).won(1, 4), [2]bool{false, true}, true},
+		{"tiebreak six five", points{}.gamesAll(6).won(0, 5).won(1, 5).won(1, 1), [2]bool{false, true}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := replay(t, preset(t, "standard"), test.points)
+			gamePoint := [2]bool{m.GamePoint(0), m.GamePoint(1)}
+			if gamePoint != test.gamePoint {
+				t.Errorf("game point %v, want %v", gamePoint, test.gamePoint)
+			}
+			if m.BreakPoint() != test.breakPt {
+				t.Errorf("break point %v, want %v", m.BreakPoint(), test.breakPt)
+			}
+		})
+	}
+}
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		points points
+	}{
+		{"mid game", "standard", points{}.won(0, 2)},
+		{"game point", "standard", points{}.games(0, 1)},
+		{"deuce game", "standard", points{}.won(0, 3).won(1, 3).won(0, 2)},
+		{"set point", "standard", points{}.games(0, 6)},
+		{"tiebreak", "standard", points{}.gamesAll(6).won(1, 7)},
+		{"match point", "standard", points{}.games(1, 12)},
+		{"match tiebreak", "matchTiebreak", points{}.games(0, 6).games(1, 6).won(0, 10)},
+		{"pro set", "proSet", points{}.games(0, 8)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format := preset(t, test.format)
+			m := replay(t, format, test.points)
+			if err := m.Undo(); err != nil {
+				t.Fatal(err)
+			}
+
+			want := replay(t, format, test.points[:len(test.points)-1])
+			if m.PointNumber() != want.PointNumber() || m.Game != want.Game || m.Tiebreak != want.Tiebreak ||
+				m.Winner != want.Winner || len(m.Sets) != len(want.Sets) || m.Server() != want.Server() {
+				t.Errorf("undo gave %+v, want %+v", m, want)
+			}
+			for i := range m.Sets {
+				if m.Sets[i].Games != want.Sets[i].Games || m.Sets[i].Winner != want.Sets[i].Winner {
+					t.Errorf("set %d is %+v, want %+v", i+1, m.Sets[i], want.Sets[i])
+				}
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	m := NewMatch(preset(t, "standard"), 0)
+	if err := m.Undo(); err != ErrNoPoints {
+		t.Errorf("undo with no points gave %v, want %v", err, ErrNoPoints)
+	}
+	if _, err := m.Apply(2); err != ErrInvalidSide {
+		t.Errorf("apply to side 2 gave %v, want %v", err, ErrInvalidSide)
+	}
+
+	m = replay(t, preset(t, "standard"), points{}.games(0, 12))
+	if _, err := m.Apply(0); err != ErrMatchComplete {
+		t.Errorf("apply after the match gave %v, want %v", err, ErrMatchComplete)
+	}
+}
+
+func TestPresetsAreValid(t *testing.T) {
+	for _, name := range []string{"standard", "bestOfFive", "matchTiebreak", "noAd", "fast4", "shortSets", "proSet"} {
+		if err := preset(t, name).Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, ok := Preset("unknown"); ok {
+		t.Error("unknown preset was found")
+	}
+}
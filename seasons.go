@@ -0,0 +1,453 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The divisions to create in a season, top division first, each a comp of its own
+type divisionRequest struct {
+	Name      string `form:"name" json:"name" binding:"required"`
+	IsPrivate bool   `form:"isPrivate" json:"isPrivate"`
+	CreatorID int    `form:"creatorID" json:"creatorID" binding:"required"`
+	Players   []int  `form:"players" json:"players"`
+}
+
+// Helper function
+//
+// Returns the season with its divisions top first, with tables when withTables is set
+// Open seasons show the live table with the places that would go up or down, closed seasons the final table
+func getSeason(q queryer, seasonID int, withTables bool) (Season, error) {
+	var season Season
+	sqlStatement := `SELECT id, name, start_date, end_date, promote, relegate, previous_season_id, closed FROM season WHERE id = $1`
+	err := q.QueryRow(sqlStatement, seasonID).Scan(&season.ID, &season.Name, &season.StartDate, &season.EndDate, &season.Promote,
+		&season.Relegate, &season.PreviousSeasonID, &season.Closed)
+	if err != nil {
+		return season, err
+	}
+
+	sqlStatement = `SELECT d.level, c.id, c.comp_name, c.is_private, c.creator_id, c.comp_type
+	FROM season_division d
+	JOIN comp c ON c.id = d.comp_id
+	WHERE d.season_id = $1
+	ORDER BY d.level`
+	rows, err := q.Query(sqlStatement, seasonID)
+	if err != nil {
+		return season, err
+	}
+	defer rows.Close()
+
+	season.Divisions = []Division{}
+	for rows.Next() {
+		var division Division
+		err = rows.Scan(&division.Level, &division.Comp.Id, &division.Comp.Name, &division.Comp.IsPrivate, &division.Comp.CreatorID,
+			&division.Comp.Type)
+		if err != nil {
+			return season, err
+		}
+		season.Divisions = append(season.Divisions, division)
+	}
+	if err = rows.Err(); err != nil {
+		return season, err
+	}
+	rows.Close()
+
+	if !withTables {
+		return season, nil
+	}
+
+	for i := range season.Divisions {
+		division := &season.Divisions[i]
+		if season.Closed {
+			division.Table, err = getFinalTable(q, season.ID, *division.Comp.Id)
+		} else {
+			division.Table, err = getDivisionTable(q, season, i)
+		}
+		if err != nil {
+			return season, err
+		}
+	}
+
+	return season, nil
+}
+
+// Returns the live table of the season's division at the index, marking the places that go up and down at the end of the season
+// Places are counted among the players still registered, players who have left are marked as leaving
+func getDivisionTable(q queryer, season Season, index int) ([]DivisionRow, error) {
+	standings, err := getCompStandings(q, *season.Divisions[index].Comp.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	registered, err := getCompPlayerIDs(q, *season.Divisions[index].Comp.Id)
+	if err != nil {
+		return nil, err
+	}
+	carried := map[int]bool{}
+	for _, playerID := range registered {
+		carried[playerID] = true
+	}
+	remaining := 0
+	for _, competitor := range standings {
+		if carried[competitor.Player.Id] {
+			remaining++
+		}
+	}
+
+	// Nobody goes up from the top division or down from the bottom, and nobody is both promoted and relegated
+	promote, relegate := season.Promote, season.Relegate
+	if index == 0 {
+		promote = 0
+	}
+	if index == len(season.Divisions)-1 {
+		relegate = 0
+	}
+	if promote > remaining {
+		promote = remaining
+	}
+	if relegate > remaining-promote {
+		relegate = remaining - promote
+	}
+
+	table := []DivisionRow{}
+	place := 0
+	for i, competitor := range standings {
+		row := DivisionRow{Position: i + 1, Competitor: competitor, Movement: MoveLeft}
+		if carried[competitor.Player.Id] {
+			row.Movement = MoveStayed
+			if place < promote {
+				row.Movement = MovePromoted
+			} else if place >= remaining-relegate {
+				row.Movement = MoveRelegated
+			}
+			place++
+		}
+		table = append(table, row)
+	}
+	return table, nil
+}
+
+// Returns the division's table as it stood when its season closed
+func getFinalTable(q queryer, seasonID, compID int) ([]DivisionRow, error) {
+	sqlStatement := `SELECT position, movement, row FROM season_standing WHERE season_id = $1 AND comp_id = $2 ORDER BY position`
+	rows, err := q.Query(sqlStatement, seasonID, compID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	table := []DivisionRow{}
+	for rows.Next() {
+		var row DivisionRow
+		var competitor []byte
+		if err = rows.Scan(&row.Position, &row.Movement, &competitor); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(competitor, &row.Competitor); err != nil {
+			return nil, err
+		}
+		table = append(table, row)
+	}
+	return table, rows.Err()
+}
+
+// Helper function
+//
+// Creates a season and a comp for each of its divisions, registering the players in each, top division first
+func createSeasonDivisions(q queryer, season *Season, divisions []divisionRequest) error {
+	sqlStatement := `INSERT INTO season (name, start_date, end_date, promote, relegate, previous_season_id, closed)
+	VALUES ($1, $2, $3, $4, $5, $6, false)
+	RETURNING id`
+	err := q.QueryRow(sqlStatement, season.Name, season.StartDate, season.EndDate, season.Promote, season.Relegate,
+		season.PreviousSeasonID).Scan(&season.ID)
+	if err != nil {
+		return err
+	}
+
+	season.Divisions = []Division{}
+	for i := range divisions {
+		request := &divisions[i]
+		division := Division{Level: i + 1, Comp: Competition{Name: &request.Name, IsPrivate: &request.IsPrivate, CreatorID: &request.CreatorID}}
+
+		sqlStatement = `INSERT INTO comp (comp_name, is_private, creator_id, comp_type)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, comp_type`
+		err = q.QueryRow(sqlStatement, request.Name, request.IsPrivate, request.CreatorID, CompLeague).Scan(&division.Comp.Id, &division.Comp.Type)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec(`INSERT INTO season_division (season_id, comp_id, level) VALUES ($1, $2, $3)`, season.ID, division.Comp.Id, division.Level)
+		if err != nil {
+			return err
+		}
+
+		// clock_timestamp keeps the players in the order given when the comp lists them by when they joined
+		for _, playerID := range request.Players {
+			sqlStatement = `INSERT INTO comp_reg (player_id, comp_id, reg_date, pending) VALUES ($1, $2, clock_timestamp(), false)`
+			_, err = q.Exec(sqlStatement, playerID, division.Comp.Id)
+			if err != nil {
+				return err
+			}
+		}
+		division.Comp.PlayerCount = len(request.Players)
+
+		season.Divisions = append(season.Divisions, division)
+	}
+
+	return nil
+}
+
+// Endpoint: /seasons
+//
+// Creates a season with its divisions, top division first, each a comp with its own matches and table
+// At the end of the season the top promote players of each division go up and the bottom relegate go down, 2 of each by default
+// Returns the season
+func createSeason(c *gin.Context) {
+	var request struct {
+		Name      string            `form:"name" json:"name" binding:"required"`
+		StartDate time.Time         `form:"startDate" json:"startDate" binding:"required"`
+		EndDate   *time.Time        `form:"endDate" json:"endDate"`
+		Promote   *int              `form:"promote" json:"promote"`
+		Relegate  *int              `form:"relegate" json:"relegate"`
+		Divisions []divisionRequest `form:"divisions" json:"divisions" binding:"required,dive"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	season := Season{Name: request.Name, StartDate: request.StartDate, EndDate: request.EndDate, Promote: 2, Relegate: 2}
+	if request.Promote != nil {
+		season.Promote = *request.Promote
+	}
+	if request.Relegate != nil {
+		season.Relegate = *request.Relegate
+	}
+	if season.Promote < 0 || season.Relegate < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "promote and relegate can't be negative"})
+		return
+	} else if len(request.Divisions) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "A season needs at least 1 division"})
+		return
+	}
+
+	// A player can only be in one division
+	seen := map[int]bool{}
+	for _, division := range request.Divisions {
+		for _, playerID := range division.Players {
+			if seen[playerID] {
+				c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Player " + strconv.Itoa(playerID) + " is in more than one division"})
+				return
+			}
+			seen[playerID] = true
+		}
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	err = createSeasonDivisions(tx, &season, request.Divisions)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, season)
+}
+
+// Endpoint: /seasons
+//
+// Returns every season newest first, with their divisions but not their tables
+func getSeasons(c *gin.Context) {
+	rows, err := db.Query(`SELECT id FROM season ORDER BY start_date DESC, id DESC`)
+	if handleError(err, c) {
+		return
+	}
+	defer rows.Close()
+
+	seasonIDs := []int{}
+	for rows.Next() {
+		var seasonID int
+		if err = rows.Scan(&seasonID); handleError(err, c) {
+			return
+		}
+		seasonIDs = append(seasonIDs, seasonID)
+	}
+	if handleError(rows.Err(), c) {
+		return
+	}
+
+	var response struct {
+		Seasons []Season `json:"seasons"`
+	}
+	response.Seasons = []Season{}
+	for _, seasonID := range seasonIDs {
+		season, err := getSeason(db, seasonID, false)
+		if handleError(err, c) {
+			return
+		}
+		response.Seasons = append(response.Seasons, season)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Endpoint: /seasons/:id
+//
+// Returns the season with each division's table, live for the current season and as it finished for past seasons
+// Rows are marked with whether the player goes up, goes down, stays or has left at the end of the season
+func getSeasonWithID(c *gin.Context) {
+	param := c.Param("id")
+	seasonID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	season, err := getSeason(db, seasonID, true)
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, season)
+}
+
+// Endpoint: /seasons/:id/rollover
+//
+// Ends the season and starts the next, keeping the final tables so the season can still be read
+// Each division carries on with the same name and table rules, the top players of each going up a division and the bottom going down
+// Players who have left a division's comp don't carry on, the places going up and down go to the players still registered
+// Returns the new season
+func rolloverSeason(c *gin.Context) {
+	param := c.Param("id")
+	seasonID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		Name      string     `form:"name" json:"name" binding:"required"`
+		StartDate time.Time  `form:"startDate" json:"startDate" binding:"required"`
+		EndDate   *time.Time `form:"endDate" json:"endDate"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if handleError(err, c) {
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the season so it can only be rolled over once
+	var closed bool
+	err = tx.QueryRow(`SELECT closed FROM season WHERE id = $1 FOR UPDATE`, seasonID).Scan(&closed)
+	if handleError(err, c) {
+		return
+	} else if closed {
+		c.JSON(http.StatusConflict, ErrorResposne{Message: "Season has already been rolled over"})
+		return
+	}
+
+	season, err := getSeason(tx, seasonID, true)
+	if handleError(err, c) {
+		return
+	}
+
+	next := Season{Name: request.Name, StartDate: request.StartDate, EndDate: request.EndDate, Promote: season.Promote,
+		Relegate: season.Relegate, PreviousSeasonID: &season.ID}
+	divisions := make([]divisionRequest, len(season.Divisions))
+	stayed := make([][]int, len(season.Divisions))
+	promoted := make([][]int, len(season.Divisions))
+	relegated := make([][]int, len(season.Divisions))
+
+	for i, division := range season.Divisions {
+		divisions[i] = divisionRequest{Name: *division.Comp.Name, IsPrivate: *division.Comp.IsPrivate, CreatorID: *division.Comp.CreatorID}
+
+		for _, row := range division.Table {
+			sqlStatement := `INSERT INTO season_standing (season_id, comp_id, position, player_id, movement, row) VALUES ($1, $2, $3, $4, $5, $6)`
+			competitor, err := json.Marshal(row.Competitor)
+			if handleError(err, c) {
+				return
+			}
+			_, err = tx.Exec(sqlStatement, season.ID, division.Comp.Id, row.Position, row.Player.Id, row.Movement, competitor)
+			if handleError(err, c) {
+				return
+			}
+
+			switch row.Movement {
+			case MovePromoted:
+				promoted[i] = append(promoted[i], row.Player.Id)
+			case MoveRelegated:
+				relegated[i] = append(relegated[i], row.Player.Id)
+			case MoveStayed:
+				stayed[i] = append(stayed[i], row.Player.Id)
+			}
+		}
+	}
+
+	// Players coming down go above those staying, and those coming up below
+	for i := range divisions {
+		if i > 0 {
+			divisions[i].Players = append(divisions[i].Players, relegated[i-1]...)
+		}
+		divisions[i].Players = append(divisions[i].Players, stayed[i]...)
+		if i < len(divisions)-1 {
+			divisions[i].Players = append(divisions[i].Players, promoted[i+1]...)
+		}
+	}
+
+	err = createSeasonDivisions(tx, &next, divisions)
+	if handleError(err, c) {
+		return
+	}
+
+	for i, division := range next.Divisions {
+		sqlStatement := `INSERT INTO table_rules (comp_id, win_points, loss_points, walkover_win_points, walkover_loss_points, tiebreakers)
+		SELECT $2, win_points, loss_points, walkover_win_points, walkover_loss_points, tiebreakers FROM table_rules WHERE comp_id = $1`
+		_, err = tx.Exec(sqlStatement, season.Divisions[i].Comp.Id, division.Comp.Id)
+		if handleError(err, c) {
+			return
+		}
+	}
+
+	sqlStatement := `UPDATE season SET closed = true, end_date = COALESCE(end_date, current_timestamp) WHERE id = $1`
+	_, err = tx.Exec(sqlStatement, season.ID)
+	if handleError(err, c) {
+		return
+	}
+
+	err = tx.Commit()
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, next)
+}
@@ -0,0 +1,240 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"tennis-api/scoring"
+)
+
+// Helper function
+//
+// Checks the point's detail against the point and the match, filling in whatever follows from the rest of the point
+// Faults, the ace and unforced error flags, the serve number and the ending are kept consistent with each other
+//
+// Returns a message describing the first problem, or an empty string if the point is valid
+func checkPointDetail(point *PointRequest, state *scoring.MatchState, teams matchTeams) string {
+	detail := &point.PointDetail
+
+	if detail.ServeDirection != nil && !oneOf(*detail.ServeDirection, ServeWide, ServeBody, ServeT) {
+		return "Serve direction must be wide, body or t"
+	}
+	if detail.Ending != nil && !oneOf(*detail.Ending, EndingWinner, EndingForcedError, EndingUnforcedError, EndingDoubleFault, EndingAce) {
+		return "Ending must be winner, forced_error, unforced_error, double_fault or ace"
+	}
+	if detail.ShotType != nil && !oneOf(*detail.ShotType, ShotServe, ShotForehand, ShotBackhand, ShotVolley, ShotOverhead) {
+		return "Shot type must be serve, forehand, backhand, volley or overhead"
+	}
+	if detail.RallyLength != nil && *detail.RallyLength < 1 {
+		return "Rally length must be at least 1"
+	}
+	if point.Faults < 0 || point.Faults > 2 {
+		return "Faults must be between 0 and 2"
+	}
+
+	// Work out the ending from the older flags if it isn't given
+	if detail.Ending == nil {
+		switch {
+		case point.Faults == 2:
+			detail.Ending = stringPtr(EndingDoubleFault)
+		case point.Ace != nil && *point.Ace:
+			detail.Ending = stringPtr(EndingAce)
+		case point.UnforcedError != nil && *point.UnforcedError:
+			detail.Ending = stringPtr(EndingUnforcedError)
+		}
+	}
+
+	ending := ""
+	if detail.Ending != nil {
+		ending = *detail.Ending
+	}
+	if point.Ace != nil && *point.Ace && ending != EndingAce {
+		return "Ace doesn't match the ending"
+	}
+	if point.UnforcedError != nil && *point.UnforcedError && ending != EndingUnforcedError {
+		return "Unforced error doesn't match the ending"
+	}
+
+	// The serve number is the serve that went in, it follows from the faults and the other way round
+	if ending == EndingDoubleFault {
+		if detail.ServeNumber != nil {
+			return "A double fault has no serve in"
+		} else if point.Faults == 1 {
+			return "A double fault needs 2 faults"
+		}
+		point.Faults = 2
+	} else if point.Faults == 2 {
+		return "2 faults is a double fault"
+	} else if detail.ServeNumber != nil {
+		if *detail.ServeNumber != 1 && *detail.ServeNumber != 2 {
+			return "Serve number must be 1 or 2"
+		} else if point.Faults != 0 && point.Faults != *detail.ServeNumber-1 {
+			return "Serve number doesn't match the faults"
+		}
+		point.Faults = *detail.ServeNumber - 1
+	} else {
+		detail.ServeNumber = intPtr(point.Faults + 1)
+	}
+
+	point.Ace = boolPtr(ending == EndingAce)
+	point.UnforcedError = boolPtr(ending == EndingUnforcedError)
+
+	if ending == "" {
+		if detail.StrikerID != nil {
+			return "A striker needs an ending"
+		}
+		return ""
+	}
+
+	// Aces and double faults are down to the server, winners to the winning team and errors to the losing team
+	serverID, _ := teams.serverReceiver(state)
+	winner := teams.side(point.WinnerID)
+	striker := 1 - winner
+	switch ending {
+	case EndingAce, EndingDoubleFault:
+		if (ending == EndingAce) != (teams.side(serverID) == winner) {
+			return "Aces are won by the server and double faults by the receiver"
+		}
+		if detail.StrikerID != nil && *detail.StrikerID != serverID {
+			return "Aces and double faults are hit by the server"
+		}
+		if detail.ShotType != nil && *detail.ShotType != ShotServe {
+			return "The shot for aces and double faults must be a serve"
+		}
+		detail.StrikerID = intPtr(serverID)
+		detail.ShotType = stringPtr(ShotServe)
+		return ""
+	case EndingWinner:
+		striker = winner
+	}
+
+	if detail.StrikerID != nil {
+		if teams.side(*detail.StrikerID) != striker {
+			return "Winners are hit by the winning team and errors by the losing team"
+		}
+	} else if len(teams[striker]) == 1 {
+		detail.StrikerID = intPtr(teams.lead(striker).Id)
+	}
+
+	return ""
+}
+
+// Helper function
+//
+// Adds the point to the serving stats of the server or their team, won is true if the serving team won the point
+// Points recorded before serve numbers were kept are counted from their faults
+func addServeStats(stats *ServeStats, point Point, won bool) {
+	stats.ServePoints++
+	if won {
+		stats.ServePointsWon++
+	}
+
+	switch point.Stats.Faults {
+	case 0:
+		stats.FirstServesIn++
+		if won {
+			stats.FirstServePointsWon++
+		}
+	case 1:
+		stats.SecondServes++
+		stats.SecondServesIn++
+		if won {
+			stats.SecondServePointsWon++
+		}
+	default:
+		stats.SecondServes++
+	}
+
+	if point.Stats.ServeDirection != nil {
+		if stats.Directions == nil {
+			stats.Directions = map[string]int{}
+		}
+		stats.Directions[*point.Stats.ServeDirection]++
+	}
+}
+
+// Helper function
+//
+// Adds the shot that ended the point to the striker's or their team's shot stats
+func addShotStats(stats *ShotStats, point Point) {
+	if point.Stats.Ending == nil {
+		return
+	}
+
+	shot := ""
+	if point.Stats.ShotType != nil {
+		shot = *point.Stats.ShotType
+	}
+
+	switch *point.Stats.Ending {
+	case EndingWinner:
+		stats.Winners++
+		if shot != "" {
+			if stats.WinnersByShot == nil {
+				stats.WinnersByShot = map[string]int{}
+			}
+			stats.WinnersByShot[shot]++
+		}
+		return
+	case EndingForcedError:
+		stats.ForcedErrors++
+	case EndingUnforcedError:
+		stats.UnforcedErrors++
+	default:
+		return
+	}
+
+	if shot != "" {
+		if stats.ErrorsByShot == nil {
+			stats.ErrorsByShot = map[string]int{}
+		}
+		stats.ErrorsByShot[shot]++
+	}
+}
+
+// Adds the point's rally length to the match's rally stats
+func addRallyStats(stats *RallyStats, point Point) {
+	if point.Stats.RallyLength == nil {
+		return
+	}
+
+	length := *point.Stats.RallyLength
+	stats.Average = (stats.Average*float64(stats.Points) + float64(length)) / float64(stats.Points+1)
+	stats.Points++
+	if length > stats.Longest {
+		stats.Longest = length
+	}
+}
+
+// Returns true if the value is one of the options
+func oneOf(value string, options ...string) bool {
+	for _, option := range options {
+		if value == option {
+			return true
+		}
+	}
+	return false
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,531 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Tiebreakers a comp's table can be ordered by
+const (
+	TiebreakHeadToHead      = "head_to_head"
+	TiebreakSetDifference   = "set_difference"
+	TiebreakGameDifference  = "game_difference"
+	TiebreakPointDifference = "point_difference"
+	TiebreakBuchholz        = "buchholz"
+	TiebreakSonnebornBerger = "sonneborn_berger"
+)
+
+// Returns the rules used by comps that haven't set their own, a point a win with ties broken by Buchholz then Sonneborn-Berger
+func defaultTableRules() TableRules {
+	return TableRules{
+		WinPoints:          1,
+		LossPoints:         0,
+		WalkoverWinPoints:  1,
+		WalkoverLossPoints: 0,
+		Tiebreakers:        []string{TiebreakBuchholz, TiebreakSonnebornBerger},
+	}
+}
+
+// Returns the comp's table rules, or the default rules if it has none
+func getTableRules(q queryer, compID int) (TableRules, error) {
+	rules := defaultTableRules()
+	sqlStatement := `SELECT win_points, loss_points, walkover_win_points, walkover_loss_points, tiebreakers
+	FROM table_rules WHERE comp_id = $1`
+	err := q.QueryRow(sqlStatement, compID).Scan(&rules.WinPoints, &rules.LossPoints, &rules.WalkoverWinPoints,
+		&rules.WalkoverLossPoints, pq.Array(&rules.Tiebreakers))
+	if err == sql.ErrNoRows {
+		return defaultTableRules(), nil
+	}
+	return rules, err
+}
+
+// Endpoint: /comps/:id/table/rules
+//
+// Returns the points and tiebreakers the comp's table is ordered by
+func getCompTableRules(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	rules, err := getTableRules(db, compID)
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// Endpoint: /comps/:id/table/rules
+//
+// Sets the points for a win, a loss and either side of a walkover, and the tiebreakers applied in order to players level on points
+// Anything left out is set to the default, a point a win with ties broken by Buchholz then Sonneborn-Berger
+// Returns the rules
+func updateCompTableRules(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		WinPoints          *int     `form:"winPoints" json:"winPoints"`
+		LossPoints         *int     `form:"lossPoints" json:"lossPoints"`
+		WalkoverWinPoints  *int     `form:"walkoverWinPoints" json:"walkoverWinPoints"`
+		WalkoverLossPoints *int     `form:"walkoverLossPoints" json:"walkoverLossPoints"`
+		Tiebreakers        []string `form:"tiebreakers" json:"tiebreakers"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	rules := defaultTableRules()
+	for _, field := range []struct {
+		value *int
+		rule  *int
+	}{
+		{request.WinPoints, &rules.WinPoints},
+		{request.LossPoints, &rules.LossPoints},
+		{request.WalkoverWinPoints, &rules.WalkoverWinPoints},
+		{request.WalkoverLossPoints, &rules.WalkoverLossPoints},
+	} {
+		if field.value != nil {
+			*field.rule = *field.value
+		}
+	}
+
+	if request.Tiebreakers != nil {
+		seen := map[string]bool{}
+		for _, tiebreaker := range request.Tiebreakers {
+			if !oneOf(tiebreaker, TiebreakHeadToHead, TiebreakSetDifference, TiebreakGameDifference, TiebreakPointDifference,
+				TiebreakBuchholz, TiebreakSonnebornBerger) {
+				c.JSON(http.StatusBadRequest, ErrorResposne{Message: fmt.Sprintf("Unknown tiebreaker %s", tiebreaker)})
+				return
+			} else if seen[tiebreaker] {
+				c.JSON(http.StatusBadRequest, ErrorResposne{Message: fmt.Sprintf("Tiebreaker %s is given twice", tiebreaker)})
+				return
+			}
+			seen[tiebreaker] = true
+		}
+		rules.Tiebreakers = request.Tiebreakers
+	}
+
+	sqlStatement := `INSERT INTO table_rules (comp_id, win_points, loss_points, walkover_win_points, walkover_loss_points, tiebreakers)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (comp_id) DO UPDATE SET win_points = $2, loss_points = $3, walkover_win_points = $4, walkover_loss_points = $5,
+		tiebreakers = $6`
+	_, err = db.Exec(sqlStatement, compID, rules.WinPoints, rules.LossPoints, rules.WalkoverWinPoints, rules.WalkoverLossPoints,
+		pq.Array(rules.Tiebreakers))
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// Helper function
+//
+// Returns the comp's table, a row for every registered player and anyone else with a result, ordered by the comp's table rules
+// Players earn the rules' points for each win, loss and walkover, and a Swiss bye is worth a win
+// Buchholz is the total points of the opponents a player has played and Sonneborn-Berger of those they have beaten,
+// doubles opponents count as their team's average
+func getCompStandings(q queryer, compID int) ([]Competitor, error) {
+	rules, err := getTableRules(q, compID)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlStatement := `SELECT p.id, p.first_name, p.last_name
+	FROM comp_reg r
+	JOIN player p ON p.id = r.player_id
+	WHERE r.comp_id = $1 AND (r.pending IS NULL OR r.pending = false)
+	ORDER BY r.reg_date, p.id`
+	rows, err := q.Query(sqlStatement, compID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	competitors := []Competitor{}
+	index := map[int]int{}
+	for rows.Next() {
+		var competitor Competitor
+		err = rows.Scan(&competitor.Player.Id, &competitor.Player.FirstName, &competitor.Player.LastName)
+		if err != nil {
+			return nil, err
+		}
+
+		index[competitor.Player.Id] = len(competitors)
+		competitors = append(competitors, competitor)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Players who have left the comp keep their results in the table
+	getCompetitor := func(playerID int) (*Competitor, error) {
+		if _, ok := index[playerID]; !ok {
+			player, err := getPlayer(q, playerID)
+			if err != nil {
+				return nil, err
+			}
+			index[playerID] = len(competitors)
+			competitors = append(competitors, Competitor{Player: player})
+		}
+		return &competitors[index[playerID]], nil
+	}
+
+	byes, err := getSwissByes(q, compID)
+	if err != nil {
+		return nil, err
+	}
+	for playerID, count := range byes {
+		competitor, err := getCompetitor(playerID)
+		if err != nil {
+			return nil, err
+		}
+		competitor.Byes = count
+		competitor.Points += count * rules.WinPoints
+	}
+
+	results, err := getCompResults(q, compID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		for side, team := range result.Teams {
+			for _, playerID := range team {
+				competitor, err := getCompetitor(playerID)
+				if err != nil {
+					return nil, err
+				}
+				competitor.add(result, side, rules)
+			}
+		}
+	}
+
+	score := func(playerID int) float64 {
+		if i, ok := index[playerID]; ok {
+			return float64(competitors[i].Points)
+		}
+		return 0
+	}
+
+	for _, result := range results {
+		for side, team := range result.Teams {
+			opponents := result.Teams[1-side]
+			var opponentScore float64
+			for _, playerID := range opponents {
+				opponentScore += score(playerID)
+			}
+			if len(opponents) > 0 {
+				opponentScore /= float64(len(opponents))
+			}
+
+			for _, playerID := range team {
+				competitor := &competitors[index[playerID]]
+				competitor.Buchholz += opponentScore
+				if result.Winner == side {
+					competitor.SonnebornBerger += opponentScore
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(competitors, func(i, j int) bool {
+		return competitors[i].Points > competitors[j].Points
+	})
+	rankTiedCompetitors(competitors, results, rules, rules.Tiebreakers)
+
+	return competitors, nil
+}
+
+// Adds a match result to the player's row, the player was on the given side
+func (competitor *Competitor) add(result compResult, side int, rules TableRules) {
+	competitor.SetsWon += result.Sets[side]
+	competitor.SetsLost += result.Sets[1-side]
+	competitor.GamesWon += result.Games[side]
+	competitor.GamesLost += result.Games[1-side]
+	competitor.PointsWon += result.Points[side]
+	competitor.PointsLost += result.Points[1-side]
+	competitor.SetDifference = competitor.SetsWon - competitor.SetsLost
+	competitor.GameDifference = competitor.GamesWon - competitor.GamesLost
+	competitor.PointDifference = competitor.PointsWon - competitor.PointsLost
+
+	competitor.Played++
+	walkover := result.Reason != nil && *result.Reason == EndWalkover
+	switch {
+	case result.Winner == side && walkover:
+		competitor.Wins++
+		competitor.Points += rules.WalkoverWinPoints
+	case result.Winner == side:
+		competitor.Wins++
+		competitor.Points += rules.WinPoints
+	case walkover:
+		competitor.Losses++
+		competitor.Points += rules.WalkoverLossPoints
+	default:
+		competitor.Losses++
+		competitor.Points += rules.LossPoints
+	}
+}
+
+// Helper function
+//
+// Orders each run of players level on points by the tiebreakers in turn, each one only separating players the ones before it couldn't
+// Head to head is the points players still level have earned under the rules in the matches between them
+func rankTiedCompetitors(competitors []Competitor, results []compResult, rules TableRules, tiebreakers []string) {
+	if len(tiebreakers) == 0 {
+		return
+	}
+
+	level := func(i, j int) bool {
+		return competitors[i].Points == competitors[j].Points
+	}
+	tiebreaker := tiebreakers[0]
+
+	for start := 0; start < len(competitors); {
+		end := start + 1
+		for end < len(competitors) && level(start, end) {
+			end++
+		}
+
+		group := competitors[start:end]
+		if len(group) > 1 {
+			keys := map[int]float64{}
+			for _, competitor := range group {
+				keys[competitor.Player.Id] = competitor.tiebreak(tiebreaker, group, results, rules)
+			}
+			sort.SliceStable(group, func(i, j int) bool {
+				return keys[group[i].Player.Id] > keys[group[j].Player.Id]
+			})
+
+			// Players still level go on to the next tiebreaker, scored as a group of their own
+			for first := 0; first < len(group); {
+				last := first + 1
+				for last < len(group) && keys[group[last].Player.Id] == keys[group[first].Player.Id] {
+					last++
+				}
+				rankTiedCompetitors(group[first:last], results, rules, tiebreakers[1:])
+				first = last
+			}
+		}
+
+		start = end
+	}
+}
+
+// Returns the player's value for the tiebreaker, higher ranks first, group is the players level with them
+func (competitor Competitor) tiebreak(tiebreaker string, group []Competitor, results []compResult, rules TableRules) float64 {
+	switch tiebreaker {
+	case TiebreakSetDifference:
+		return float64(competitor.SetDifference)
+	case TiebreakGameDifference:
+		return float64(competitor.GameDifference)
+	case TiebreakPointDifference:
+		return float64(competitor.PointDifference)
+	case TiebreakBuchholz:
+		return competitor.Buchholz
+	case TiebreakSonnebornBerger:
+		return competitor.SonnebornBerger
+	}
+
+	// Head to head, the table points from matches played only against others in the group
+	inGroup := map[int]bool{}
+	for _, other := range group {
+		inGroup[other.Player.Id] = true
+	}
+
+	var headToHead Competitor
+	for _, result := range results {
+		if !result.only(inGroup) {
+			continue
+		}
+		if side := result.side(competitor.Player.Id); side >= 0 {
+			headToHead.add(result, side, rules)
+		}
+	}
+	return float64(headToHead.Points)
+}
+
+// A counted match in a comp, the players on each side, the side that won, -1 if nobody did,
+// and the sets, games and points each side won
+type compResult struct {
+	Teams  [2][]int
+	Winner int
+	Reason *string
+	Sets   [2]int
+	Games  [2]int
+	Points [2]int
+}
+
+// Returns true if every player in the match is in the set
+func (result compResult) only(players map[int]bool) bool {
+	for _, team := range result.Teams {
+		for _, playerID := range team {
+			if !players[playerID] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the side the player is on, -1 if they didn't play
+func (result compResult) side(playerID int) int {
+	for side, team := range result.Teams {
+		for _, id := range team {
+			if id == playerID {
+				return side
+			}
+		}
+	}
+	return -1
+}
+
+// Helper function
+//
+// Returns every finished match in the comp that counts towards the table, along with the sets, games and points each side won
+// Abandoned matches don't count
+func getCompResults(q queryer, compID int) ([]compResult, error) {
+	sqlStatement := `SELECT mp.match_id, mp.player_id, mp.team, mr.winner_id, mr.reason
+	FROM match_participant mp
+	JOIN match m ON m.id = mp.match_id
+	JOIN match_result mr ON mr.match_id = m.id
+	WHERE m.comp_id = $1 AND (mr.reason IS NULL OR mr.reason != 'abandoned')
+	ORDER BY mp.match_id, mp.team, mp.serve_order, mp.player_id`
+	rows, err := q.Query(sqlStatement, compID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []compResult{}
+	index := map[int]int{}
+	winners := map[int]*int{}
+	for rows.Next() {
+		var matchID, playerID int
+		var team, winnerID *int
+		var reason *string
+		if err = rows.Scan(&matchID, &playerID, &team, &winnerID, &reason); err != nil {
+			return nil, err
+		}
+
+		if _, ok := index[matchID]; !ok {
+			index[matchID] = len(results)
+			winners[matchID] = winnerID
+			results = append(results, compResult{Winner: -1, Reason: reason})
+		}
+
+		// Matches from before teams were recorded alternate sides, the same as getMatchTeams
+		result := &results[index[matchID]]
+		side := (len(result.Teams[0]) + len(result.Teams[1])) % 2
+		if team != nil {
+			side = *team - 1
+		}
+		result.Teams[side] = append(result.Teams[side], playerID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for matchID, winnerID := range winners {
+		if winnerID != nil {
+			result := &results[index[matchID]]
+			result.Winner = result.side(*winnerID)
+		}
+	}
+
+	// Sets, games and points are won by the lead player of a team
+	counts := []struct {
+		sql   string
+		field func(result *compResult) *[2]int
+	}{
+		{`SELECT s.match_id, s.winner_id, COUNT(*) FROM set s
+		JOIN match m ON m.id = s.match_id
+		WHERE m.comp_id = $1 AND s.winner_id IS NOT NULL
+		GROUP BY s.match_id, s.winner_id`, func(result *compResult) *[2]int { return &result.Sets }},
+		{`SELECT s.match_id, g.winner_id, COUNT(*) FROM game g
+		JOIN set s ON s.id = g.set_id
+		JOIN match m ON m.id = s.match_id
+		WHERE m.comp_id = $1 AND g.winner_id IS NOT NULL
+		GROUP BY s.match_id, g.winner_id`, func(result *compResult) *[2]int { return &result.Games }},
+		{`SELECT p.match_id, p.winner_id, COUNT(*) FROM point p
+		JOIN match m ON m.id = p.match_id
+		WHERE m.comp_id = $1 AND p.winner_id IS NOT NULL
+		GROUP BY p.match_id, p.winner_id`, func(result *compResult) *[2]int { return &result.Points }},
+	}
+
+	for _, count := range counts {
+		rows, err := q.Query(count.sql, compID)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var matchID, winnerID, won int
+			if err = rows.Scan(&matchID, &winnerID, &won); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			i, ok := index[matchID]
+			if !ok {
+				continue
+			}
+			if side := results[i].side(winnerID); side != -1 {
+				count.field(&results[i])[side] += won
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Returns the number of Swiss byes each player in the comp has had
+func getSwissByes(q queryer, compID int) (map[int]int, error) {
+	rows, err := q.Query(`SELECT player_id, COUNT(*) FROM swiss_bye WHERE comp_id = $1 GROUP BY player_id`, compID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byes := map[int]int{}
+	for rows.Next() {
+		var playerID, count int
+		if err = rows.Scan(&playerID, &count); err != nil {
+			return nil, err
+		}
+		byes[playerID] = count
+	}
+	return byes, rows.Err()
+}
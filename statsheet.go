@@ -0,0 +1,343 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function
+//
+// Replays the match's points to build the serve and return stat sheet for each team and each player
+// Serve stats go to the server, return points and break point chances to the receiver, and return games to the whole receiving team
+//
+// Returns a sheet for each team and a sheet for each player keyed by their id, with percentages filled in
+func getStatSheets(q queryer, matchID int) ([2]StatSheet, map[int]*StatSheet, error) {
+	var sides [2]StatSheet
+	players := map[int]*StatSheet{}
+
+	replay, teams, points, err := getMatchStart(q, matchID)
+	if err != nil {
+		return sides, players, err
+	}
+
+	// Only matches with points played count towards the number of matches on a sheet
+	played := 0
+	if len(points) > 0 {
+		played = 1
+	}
+	for _, team := range teams {
+		for _, player := range team {
+			players[player.Id] = &StatSheet{Matches: played}
+		}
+	}
+	sides[0].Matches, sides[1].Matches = played, played
+
+	for _, point := range points {
+		winner := teams.side(*point.WinnerID)
+		if winner < 0 {
+			return sides, players, fmt.Errorf("point %d was won by player %d who is not in match %d", *point.PointNum, *point.WinnerID, matchID)
+		}
+		server := replay.Server()
+		serverID, receiverID := teams.serverReceiver(replay)
+		breakPoint := replay.BreakPoint()
+		tiebreak := replay.Tiebreak
+
+		result, err := replay.Apply(winner)
+		if err != nil {
+			return sides, players, err
+		}
+
+		serverWon := winner == server
+		for _, sheet := range []*StatSheet{&sides[server], players[serverID]} {
+			sheet.addServePoint(point, serverWon, breakPoint)
+			if result.GameWon && !tiebreak {
+				sheet.addServiceGame(serverWon)
+			}
+		}
+		for _, sheet := range []*StatSheet{&sides[1-server], players[receiverID]} {
+			sheet.addReturnPoint(!serverWon, breakPoint)
+		}
+		if result.GameWon && !tiebreak {
+			sides[1-server].addReturnGame(!serverWon)
+			for _, player := range teams[1-server] {
+				players[player.Id].addReturnGame(!serverWon)
+			}
+		}
+	}
+
+	for side := range sides {
+		sides[side].finish()
+	}
+	for _, sheet := range players {
+		sheet.finish()
+	}
+
+	return sides, players, nil
+}
+
+func (s *StatSheet) addServePoint(point PointEvent, won, breakPoint bool) {
+	s.ServePoints++
+	s.PointsPlayed++
+	switch {
+	case point.Faults == 0:
+		s.FirstServesIn++
+		if won {
+			s.FirstServePointsWon++
+		}
+	default:
+		s.SecondServePoints++
+		if won {
+			s.SecondServePointsWon++
+		}
+	}
+	if point.Faults >= 2 {
+		s.DoubleFaults++
+	}
+	if point.Ace != nil && *point.Ace {
+		s.Aces++
+	}
+	if won {
+		s.ServePointsWon++
+		s.PointsWon++
+	}
+	if breakPoint {
+		s.BreakPointsFaced++
+		if won {
+			s.BreakPointsSaved++
+		}
+	}
+}
+
+func (s *StatSheet) addReturnPoint(won, breakPoint bool) {
+	s.ReturnPoints++
+	s.PointsPlayed++
+	if won {
+		s.ReturnPointsWon++
+		s.PointsWon++
+	}
+	if breakPoint {
+		s.BreakPointChances++
+		if won {
+			s.BreakPointsConverted++
+		}
+	}
+}
+
+func (s *StatSheet) addServiceGame(held bool) {
+	s.ServiceGames++
+	if held {
+		s.ServiceGamesHeld++
+	}
+}
+
+func (s *StatSheet) addReturnGame(broken bool) {
+	s.ReturnGames++
+	if broken {
+		s.ReturnGamesWon++
+	}
+}
+
+// Adds the counts from another sheet, the percentages need filling in again afterwards
+func (s *StatSheet) add(other StatSheet) {
+	s.Matches += other.Matches
+	s.PointsPlayed += other.PointsPlayed
+	s.PointsWon += other.PointsWon
+	s.ServePoints += other.ServePoints
+	s.ServePointsWon += other.ServePointsWon
+	s.FirstServesIn += other.FirstServesIn
+	s.FirstServePointsWon += other.FirstServePointsWon
+	s.SecondServePoints += other.SecondServePoints
+	s.SecondServePointsWon += other.SecondServePointsWon
+	s.Aces += other.Aces
+	s.DoubleFaults += other.DoubleFaults
+	s.BreakPointsFaced += other.BreakPointsFaced
+	s.BreakPointsSaved += other.BreakPointsSaved
+	s.BreakPointChances += other.BreakPointChances
+	s.BreakPointsConverted += other.BreakPointsConverted
+	s.ServiceGames += other.ServiceGames
+	s.ServiceGamesHeld += other.ServiceGamesHeld
+	s.ReturnGames += other.ReturnGames
+	s.ReturnGamesWon += other.ReturnGamesWon
+	s.ReturnPoints += other.ReturnPoints
+	s.ReturnPointsWon += other.ReturnPointsWon
+}
+
+// Fills in the percentages from the counts
+func (s *StatSheet) finish() {
+	s.FirstServePct = percent(s.FirstServesIn, s.ServePoints)
+	s.FirstServeWonPct = percent(s.FirstServePointsWon, s.FirstServesIn)
+	s.SecondServeWonPct = percent(s.SecondServePointsWon, s.SecondServePoints)
+	s.ServePointsWonPct = percent(s.ServePointsWon, s.ServePoints)
+	s.BreakPointsSavedPct = percent(s.BreakPointsSaved, s.BreakPointsFaced)
+	s.BreakPointsConvertedPct = percent(s.BreakPointsConverted, s.BreakPointChances)
+	s.HoldPct = percent(s.ServiceGamesHeld, s.ServiceGames)
+	s.BreakPct = percent(s.ReturnGamesWon, s.ReturnGames)
+	s.ReturnPointsWonPct = percent(s.ReturnPointsWon, s.ReturnPoints)
+	s.PointsWonPct = percent(s.PointsWon, s.PointsPlayed)
+}
+
+// Returns part as a percentage of total to one decimal place, 0 when there is no total
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)*1000/float64(total)) / 10
+}
+
+// Endpoint: /stats/matches
+//
+// Returns stat sheets for each player summed over a range of matches
+// Matches can be picked by id with matchIDs, or filtered by compID, playerID and a from and to start date
+// At least one filter is required
+func getMatchRangeStats(c *gin.Context) {
+	var request struct {
+		MatchIDs string     `form:"matchIDs"`
+		CompID   *int       `form:"compID"`
+		PlayerID *int       `form:"playerID"`
+		From     *time.Time `form:"from"`
+		To       *time.Time `form:"to"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	filters := []string{"TRUE"}
+	args := []interface{}{}
+	addFilter := func(filter string, arg interface{}) {
+		args = append(args, arg)
+		filters = append(filters, fmt.Sprintf(filter, len(args)))
+	}
+
+	if request.MatchIDs != "" {
+		ids := []string{}
+		for _, id := range strings.Split(request.MatchIDs, ",") {
+			if _, err := strconv.Atoi(strings.TrimSpace(id)); err != nil {
+				c.JSON(http.StatusBadRequest, ErrorResposne{Message: "matchIDs must be a comma separated list of ids"})
+				return
+			}
+			ids = append(ids, strings.TrimSpace(id))
+		}
+		addFilter("match.id = ANY(string_to_array($%d, ',')::int[])", strings.Join(ids, ","))
+	}
+	if request.CompID != nil {
+		addFilter("match.comp_id = $%d", *request.CompID)
+	}
+	if request.PlayerID != nil {
+		addFilter("match.id IN (SELECT match_id FROM match_participant WHERE player_id = $%d)", *request.PlayerID)
+	}
+	if request.From != nil {
+		addFilter("match.start_date >= $%d", *request.From)
+	}
+	if request.To != nil {
+		addFilter("match.start_date <= $%d", *request.To)
+	}
+
+	// Every match is replayed to build the sheets, so summing the whole database isn't allowed
+	if len(args) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: "At least one of matchIDs, compID, playerID, from or to is required"})
+		return
+	}
+
+	matchIDs, err := queryMatchIDs(`SELECT match.id FROM match WHERE `+strings.Join(filters, " AND ")+` ORDER BY match.id`, args...)
+	if handleError(err, c) {
+		return
+	}
+
+	players, err := sumStatSheets(matchIDs)
+	if handleError(err, c) {
+		return
+	}
+
+	var response struct {
+		Matches int               `json:"matches"`
+		Players []PlayerStatSheet `json:"players"`
+	}
+	response.Matches = len(matchIDs)
+	response.Players = []PlayerStatSheet{}
+	for _, player := range players {
+		if request.PlayerID == nil || player.Player.Id == *request.PlayerID {
+			response.Players = append(response.Players, player)
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Returns the ids from a query selecting match ids
+func queryMatchIDs(sqlStatement string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(sqlStatement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matchIDs := []int{}
+	for rows.Next() {
+		var matchID int
+		if err = rows.Scan(&matchID); err != nil {
+			return nil, err
+		}
+		matchIDs = append(matchIDs, matchID)
+	}
+	return matchIDs, rows.Err()
+}
+
+// Helper function
+//
+// Sums each player's stat sheets over the matches
+//
+// Returns a sheet for every player who played in the matches, in the order they first appear
+func sumStatSheets(matchIDs []int) ([]PlayerStatSheet, error) {
+	sheets := []PlayerStatSheet{}
+	index := map[int]int{}
+
+	for _, matchID := range matchIDs {
+		_, players, err := getStatSheets(db, matchID)
+		if err != nil {
+			return nil, err
+		}
+
+		teams, err := getMatchTeams(db, matchID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, team := range teams {
+			for _, player := range team {
+				i, ok := index[player.Id]
+				if !ok {
+					i = len(sheets)
+					index[player.Id] = i
+					sheets = append(sheets, PlayerStatSheet{Player: player})
+				}
+				sheets[i].StatSheet.add(*players[player.Id])
+			}
+		}
+	}
+
+	for i := range sheets {
+		sheets[i].StatSheet.finish()
+	}
+	return sheets, nil
+}
@@ -0,0 +1,449 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Attempts made to deliver a webhook before it is marked as failed
+const webhookMaxAttempts = 6
+
+// Wait before the first retry, doubled for each retry after
+var webhookBackoff = 5 * time.Second
+
+// Schemes webhook URLs can use
+var webhookSchemes = []string{"https"}
+
+// Returns true if webhooks can be sent to the address
+var webhookAddressAllowed = isPublicIP
+
+// Checks the address being connected to as well as the one checked when the webhook was created,
+// so a host that later resolves to an internal address, or a redirect to one, is refused
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, conn syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip) {
+					return errors.New("webhook address " + host + " is not allowed")
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// Returns true if the event is one webhooks can subscribe to
+func isWebhookEvent(event string) bool {
+	switch event {
+	case WebhookMatchCreated, WebhookPointScored, WebhookMatchCompleted, WebhookPlayerJoined, WebhookInviteAccepted:
+		return true
+	}
+	return false
+}
+
+// Helper function
+//
+// Returns an error unless the URL is absolute with an allowed scheme, https, and its host only resolves to allowed addresses
+func validateWebhookURL(rawURL string) error {
+	target, err := url.Parse(rawURL)
+	schemeAllowed := false
+	for _, scheme := range webhookSchemes {
+		schemeAllowed = schemeAllowed || err == nil && target.Scheme == scheme
+	}
+	if !schemeAllowed || target.Hostname() == "" {
+		return errors.New("URL must be an absolute " + strings.Join(webhookSchemes, " or ") + " URL")
+	}
+
+	ips := []net.IP{net.ParseIP(target.Hostname())}
+	if ips[0] == nil {
+		ips, err = net.LookupIP(target.Hostname())
+		if err != nil || len(ips) == 0 {
+			return errors.New("URL host " + target.Hostname() + " could not be resolved")
+		}
+	}
+
+	for _, ip := range ips {
+		if !webhookAddressAllowed(ip) {
+			return errors.New("URL host " + target.Hostname() + " is not a public address")
+		}
+	}
+	return nil
+}
+
+// Returns false for loopback, private, link local, multicast and unspecified addresses
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// Endpoint: /comps/:id/webhooks
+//
+// Subscribes a URL to events in the comp, deliveries are signed with the secret
+// Returns the new webhook
+func createWebhook(c *gin.Context) {
+	param := c.Param("id")
+	compID, err := strconv.Atoi(param)
+	if handleError(err, c) {
+		return
+	}
+
+	var request struct {
+		URL    string   `json:"url" binding:"required"`
+		Secret string   `json:"secret" binding:"required"`
+		Events []string `json:"events" binding:"required"`
+	}
+
+	if !tryGetRequest(c, &request) {
+		return
+	}
+
+	if err := validateWebhookURL(request.URL); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResposne{Message: err.Error()})
+		return
+	}
+
+	for _, event := range request.Events {
+		if !isWebhookEvent(event) {
+			c.JSON(http.StatusBadRequest, ErrorResposne{Message: "Unknown event " + event})
+			return
+		}
+	}
+
+	webhook := Webhook{CompID: compID, URL: request.URL, Events: request.Events}
+	sqlStatement := `INSERT INTO webhook (comp_id, url, secret, events, created_at)
+	VALUES ($1, $2, $3, $4, current_timestamp)
+	RETURNING id, created_at`
+	err = db.QueryRow(sqlStatement, compID, request.URL, request.Secret, pq.Array(request.Events)).Scan(&webhook.ID, &webhook.CreatedAt)
+	if handleError(err, c) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// Endpoint: /comps/:id/webhooks
+//
+// Returns the comp's webhooks, secrets are never returned
+func getWebhooks(c *gin.Context) {
+	compID := c.Param("id")
+
+	sqlStatement := `SELECT id, comp_id, url, events, created_at FROM webhook WHERE comp_id = $1 ORDER BY id`
+	rows, err := db.Query(sqlStatement, compID)
+	if handleError(err, c) {
+		return
+	}
+	defer rows.Close()
+
+	var response struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}
+	response.Webhooks = []Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		err = rows.Scan(&webhook.ID, &webhook.CompID, &webhook.URL, pq.Array(&webhook.Events), &webhook.CreatedAt)
+		if handleError(err, c) {
+			return
+		}
+		response.Webhooks = append(response.Webhooks, webhook)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Endpoint: /comps/:id/webhooks/:webhookID
+//
+// Deletes the webhook along with its delivery log
+func deleteWebhook(c *gin.Context) {
+	compID := c.Param("id")
+	webhookID := c.Param("webhookID")
+
+	sqlStatement := `WITH deliveries AS (
+		DELETE FROM webhook_delivery WHERE webhook_id IN (SELECT id FROM webhook WHERE id = $1 AND comp_id = $2)
+	)
+	DELETE FROM webhook WHERE id = $1 AND comp_id = $2`
+	result, err := db.Exec(sqlStatement, webhookID, compID)
+	if handleError(err, c) {
+		return
+	}
+
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// Endpoint: /comps/:id/webhooks/:webhookID/deliveries
+//
+// Returns the webhook's deliveries, the most recent first
+func getWebhookDeliveries(c *gin.Context) {
+	compID := c.Param("id")
+	webhookID := c.Param("webhookID")
+
+	sqlStatement := `SELECT webhook_delivery.id, webhook_id, event, payload, status, attempts, response_status, error,
+		next_attempt, webhook_delivery.created_at, delivered_at
+	FROM webhook_delivery
+	JOIN webhook ON webhook.id = webhook_delivery.webhook_id
+	WHERE webhook_id = $1 AND webhook.comp_id = $2
+	ORDER BY webhook_delivery.id DESC
+	LIMIT 100`
+	rows, err := db.Query(sqlStatement, webhookID, compID)
+	if handleError(err, c) {
+		return
+	}
+	defer rows.Close()
+
+	var response struct {
+		Deliveries []WebhookDelivery `json:"deliveries"`
+	}
+	response.Deliveries = []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload []byte
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Status, &delivery.Attempts,
+			&delivery.ResponseStatus, &delivery.Error, &delivery.NextAttempt, &delivery.CreatedAt, &delivery.DeliveredAt)
+		if handleError(err, c) {
+			return
+		}
+		delivery.Payload = json.RawMessage(payload)
+		response.Deliveries = append(response.Deliveries, delivery)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Helper function
+//
+// Queues a delivery of the event to every webhook in the comp subscribed to it, then sends them in the background
+// A failed insert is only logged, the match or registration that triggered the event is already saved
+func fireWebhooks(compID int, event string, data interface{}) {
+	payload, err := json.Marshal(WebhookPayload{Event: event, CompID: compID, CreatedAt: time.Now(), Data: data})
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	sqlStatement := `INSERT INTO webhook_delivery (webhook_id, event, payload, status, attempts, next_attempt, created_at)
+	SELECT id, $2, $3, $4, 0, current_timestamp, current_timestamp
+	FROM webhook
+	WHERE comp_id = $1 AND $2 = ANY(events)
+	RETURNING id`
+	rows, err := db.Query(sqlStatement, compID, event, payload, DeliveryPending)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var deliveryID int
+		if err = rows.Scan(&deliveryID); err != nil {
+			println(err.Error())
+			return
+		}
+		go deliverWebhook(deliveryID, 0)
+	}
+}
+
+// Helper function
+//
+// Fires the event for the match's comp with the match and its current score
+func fireMatchWebhooks(matchID int, events ...string) {
+	var compID *int
+	sqlStatement := `SELECT comp_id FROM match WHERE id = $1`
+	err := db.QueryRow(sqlStatement, matchID).Scan(&compID)
+	if err != nil || compID == nil {
+		return
+	}
+
+	for _, event := range events {
+		var subscribed bool
+		sqlStatement = `SELECT EXISTS (SELECT 1 FROM webhook WHERE comp_id = $1 AND $2 = ANY(events))`
+		err = db.QueryRow(sqlStatement, *compID, event).Scan(&subscribed)
+		if err != nil {
+			println(err.Error())
+			continue
+		} else if !subscribed {
+			continue
+		}
+
+		data, err := getLiveEvent(matchID, event)
+		if err != nil {
+			println(err.Error())
+			continue
+		}
+		fireWebhooks(*compID, event, data)
+	}
+}
+
+// Helper function
+//
+// Fires the event for the comp with the player who joined it
+func firePlayerWebhooks(compID, playerID int, events ...string) {
+	var data struct {
+		CompID int    `json:"compID"`
+		Player Player `json:"player"`
+	}
+	data.CompID = compID
+
+	sqlStatement := `SELECT id, first_name, last_name FROM player WHERE id = $1`
+	err := db.QueryRow(sqlStatement, playerID).Scan(&data.Player.Id, &data.Player.FirstName, &data.Player.LastName)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	for _, event := range events {
+		fireWebhooks(compID, event, data)
+	}
+}
+
+// Helper function
+//
+// Sends the delivery after waiting, retrying with exponential backoff until it succeeds or runs out of attempts
+// Each attempt is recorded in the delivery log
+func deliverWebhook(deliveryID int, wait time.Duration) {
+	time.Sleep(wait)
+
+	var targetURL, secret, event string
+	var payload []byte
+	var attempts int
+	sqlStatement := `SELECT url, secret, event, payload, attempts
+	FROM webhook_delivery
+	JOIN webhook ON webhook.id = webhook_delivery.webhook_id
+	WHERE webhook_delivery.id = $1 AND status = $2`
+	err := db.QueryRow(sqlStatement, deliveryID, DeliveryPending).Scan(&targetURL, &secret, &event, &payload, &attempts)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	responseStatus, err := sendWebhook(targetURL, secret, event, deliveryID, payload)
+	attempts++
+
+	var errMessage *string
+	status := DeliverySucceeded
+	if err != nil {
+		message := err.Error()
+		errMessage = &message
+		status = DeliveryPending
+		if attempts >= webhookMaxAttempts {
+			status = DeliveryFailed
+		}
+	}
+
+	backoff := webhookBackoff << (attempts - 1)
+	sqlStatement = `UPDATE webhook_delivery SET status = $2, attempts = $3, response_status = $4, error = $5,
+		next_attempt = CASE WHEN $2 = 'pending' THEN current_timestamp + $6 * interval '1 millisecond' END,
+		delivered_at = CASE WHEN $2 = 'succeeded' THEN current_timestamp END
+	WHERE id = $1`
+	_, err = db.Exec(sqlStatement, deliveryID, status, attempts, responseStatus, errMessage, backoff.Milliseconds())
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	if status == DeliveryPending {
+		go deliverWebhook(deliveryID, backoff)
+	}
+}
+
+// Helper function
+//
+// Posts the payload to the URL, signed with an HMAC-SHA256 of the timestamp and body
+// Returns the response status, and an error unless the receiver responded with a 2xx status
+func sendWebhook(targetURL, secret, event string, deliveryID int, payload []byte) (*int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request, err := http.NewRequest(http.MethodPost, targetURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Event", event)
+	request.Header.Set("X-Webhook-Delivery", strconv.Itoa(deliveryID))
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(secret, timestamp, payload))
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return &response.StatusCode, fmt.Errorf("receiver responded with %s", response.Status)
+	}
+	return &response.StatusCode, nil
+}
+
+// Returns the hex HMAC-SHA256 of the timestamp and payload, joined by a full stop
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Helper function
+//
+// Picks up deliveries still pending from before the server restarted
+func resumeWebhookDeliveries() {
+	sqlStatement := `SELECT id, GREATEST(EXTRACT(EPOCH FROM next_attempt - current_timestamp), 0)
+	FROM webhook_delivery
+	WHERE status = $1`
+	rows, err := db.Query(sqlStatement, DeliveryPending)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var deliveryID int
+		var wait float64
+		if err = rows.Scan(&deliveryID, &wait); err != nil {
+			println(err.Error())
+			return
+		}
+		go deliverWebhook(deliveryID, time.Duration(wait*float64(time.Second)))
+	}
+}
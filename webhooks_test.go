@@ -0,0 +1,209 @@
+// Copyright 2021 Stephen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const webhookTestSchema = `
+CREATE TABLE webhook (id serial PRIMARY KEY, comp_id int, url text, secret text, events text[], created_at timestamptz);
+CREATE TABLE webhook_delivery (id serial PRIMARY KEY, webhook_id int, event text, payload bytea, status text, attempts int,
+	response_status int, error text, next_attempt timestamptz, created_at timestamptz, delivered_at timestamptz);
+`
+
+// Lets webhooks go to a local http test server and retry quickly until the test ends
+func allowLocalWebhooks(t *testing.T) {
+	schemes, allowed, backoff := webhookSchemes, webhookAddressAllowed, webhookBackoff
+	webhookSchemes = []string{"http", "https"}
+	webhookAddressAllowed = func(net.IP) bool { return true }
+	webhookBackoff = 10 * time.Millisecond
+	t.Cleanup(func() {
+		webhookSchemes, webhookAddressAllowed, webhookBackoff = schemes, allowed, backoff
+	})
+}
+
+// A receiver that fails the first failures requests with a 500, recording every request it is sent
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, request)
+	r.bodies = append(r.bodies, body)
+	r.times = append(r.times, time.Now())
+	if len(r.requests) <= r.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://93.184.216.34/hook", true},
+		{"https://[2606:2800:220:1::1]:8443/hook", true},
+		{"http://93.184.216.34/hook", false},
+		{"ftp://93.184.216.34/hook", false},
+		{"/hook", false},
+		{"https://127.0.0.1/hook", false},
+		{"https://[::1]/hook", false},
+		{"https://10.1.2.3/hook", false},
+		{"https://172.16.0.1/hook", false},
+		{"https://192.168.1.1/hook", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://0.0.0.0/hook", false},
+		{"https://[fd00::1]/hook", false},
+		{"https://localhost/hook", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			if err := validateWebhookURL(test.url); (err == nil) != test.valid {
+				t.Errorf("got %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	receiver := &webhookReceiver{failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	// Local addresses are refused when connecting as well as when the webhook is created
+	if _, err := sendWebhook(server.URL, "secret", WebhookPointScored, 7, []byte(`{}`)); err == nil {
+		t.Fatal("sent to a local address")
+	}
+
+	allowLocalWebhooks(t)
+	if err := validateWebhookURL(server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"event":"point.scored"}`)
+	for _, want := range []int{http.StatusInternalServerError, http.StatusOK} {
+		status, err := sendWebhook(server.URL, "secret", WebhookPointScored, 7, payload)
+		if status == nil || *status != want || (err == nil) != (want == http.StatusOK) {
+			t.Fatalf("got status %v and error %v, want %d", status, err, want)
+		}
+	}
+
+	request, body := receiver.requests[1], receiver.bodies[1]
+	if string(body) != string(payload) {
+		t.Errorf("body %s, want %s", body, payload)
+	}
+	if request.Header.Get("X-Webhook-Event") != WebhookPointScored || request.Header.Get("X-Webhook-Delivery") != "7" {
+		t.Errorf("event %s delivery %s", request.Header.Get("X-Webhook-Event"), request.Header.Get("X-Webhook-Delivery"))
+	}
+
+	// The receiver can check the signature from the secret, timestamp header and body
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(request.Header.Get("X-Webhook-Timestamp") + "." + string(body)))
+	if signature := "sha256=" + hex.EncodeToString(mac.Sum(nil)); request.Header.Get("X-Webhook-Signature") != signature {
+		t.Errorf("signature %s, want %s", request.Header.Get("X-Webhook-Signature"), signature)
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		status   string
+		attempts int
+		response int
+	}{
+		{"first attempt", 0, DeliverySucceeded, 1, http.StatusOK},
+		{"after retries", 2, DeliverySucceeded, 3, http.StatusOK},
+		{"gives up", webhookMaxAttempts, DeliveryFailed, webhookMaxAttempts, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestDatabase(t, webhookTestSchema)
+			allowLocalWebhooks(t)
+
+			receiver := &webhookReceiver{failures: test.failures}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			setup := []string{
+				`INSERT INTO webhook (id, comp_id, url, secret, events) VALUES (1, 1, '` + server.URL + `', 'secret', '{point.scored}')`,
+				`INSERT INTO webhook_delivery (id, webhook_id, event, payload, status, attempts) VALUES (1, 1, 'point.scored', '{}', 'pending', 0)`,
+			}
+			for _, statement := range setup {
+				if _, err := db.Exec(statement); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Retries are sent in the background, so wait for the delivery to settle
+			deliverWebhook(1, 0)
+			var status string
+			var attempts int
+			var response *int
+			var errMessage *string
+			var nextAttempt, deliveredAt *time.Time
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+				err := db.QueryRow(`SELECT status, attempts, response_status, error, next_attempt, delivered_at FROM webhook_delivery WHERE id = 1`).
+					Scan(&status, &attempts, &response, &errMessage, &nextAttempt, &deliveredAt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status != DeliveryPending || time.Now().After(deadline) {
+					break
+				}
+			}
+
+			if status != test.status || attempts != test.attempts || response == nil || *response != test.response {
+				t.Fatalf("status %s after %d attempts with response %v, want %s after %d with %d", status, attempts, response,
+					test.status, test.attempts, test.response)
+			}
+			if (errMessage == nil) != (status == DeliverySucceeded) || (deliveredAt == nil) != (status != DeliverySucceeded) || nextAttempt != nil {
+				t.Errorf("error %v, delivered at %v, next attempt %v", errMessage, deliveredAt, nextAttempt)
+			}
+
+			// Each retry waits twice as long as the one before
+			receiver.mu.Lock()
+			defer receiver.mu.Unlock()
+			if len(receiver.times) != test.attempts {
+				t.Fatalf("receiver was sent %d requests, want %d", len(receiver.times), test.attempts)
+			}
+			for i := 1; i < len(receiver.times); i++ {
+				if wait := receiver.times[i].Sub(receiver.times[i-1]); wait < webhookBackoff<<(i-1) {
+					t.Errorf("retry %d sent after %v, want at least %v", i, wait, webhookBackoff<<(i-1))
+				}
+			}
+		})
+	}
+}